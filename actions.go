@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Action is a user action attached to an ntfy message. Only http actions are
+// ever invoked by the bot, with -ack-actions.
+type Action struct {
+	Id      string            `json:"id,omitempty"`
+	Action  string            `json:"action"`
+	Label   string            `json:"label"`
+	Url     string            `json:"url,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+// ackActions fires every http action of msg, as ntfy's own clients would
+// when the action's button is pressed. Failures are logged, not returned, as
+// the message has already been delivered.
+func ackActions(client *http.Client, msg NtfyMessage) {
+	for _, action := range msg.Actions {
+		if action.Action != "http" {
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("dry-run: would ack message %s with %s %s\n", msg.Id, action.method(), redactUrl(action.Url))
+			continue
+		}
+		if err := invokeAction(client, action); err != nil {
+			fmt.Printf("warning: ack action %q for message %s failed: %s\n", action.Label, msg.Id, err)
+		}
+	}
+}
+
+// method returns the action's HTTP method, which ntfy defaults to POST.
+func (a Action) method() string {
+	if a.Method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(a.Method)
+}
+
+func invokeAction(client *http.Client, action Action) error {
+	req, err := http.NewRequest(action.method(), action.Url, strings.NewReader(action.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", *userAgent)
+	for key, value := range action.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	debugf("ack action %s %s responded with %s", action.method(), redactUrl(action.Url), resp.Status)
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s responded with %s", action.method(), redactUrl(action.Url), resp.Status)
+	}
+	return nil
+}
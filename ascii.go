@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// asciiReplacements transliterates common accented letters and typographic
+// punctuation for -ascii-fallback.
+var asciiReplacements = map[rune]string{
+	'À': "A", 'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE", 'Ç': "C",
+	'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I", 'Î': "I", 'Ï': "I",
+	'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O", 'Õ': "O", 'Ö': "O", 'Ø': "O",
+	'Ù': "U", 'Ú': "U", 'Û': "U", 'Ü': "U", 'Ý': "Y", 'Þ': "TH", 'ß': "ss",
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i",
+	'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ý': "y", 'þ': "th", 'ÿ': "y",
+	'Œ': "OE", 'œ': "oe", 'Š': "S", 'š': "s", 'Ž': "Z", 'ž': "z", 'Ł': "L", 'ł': "l",
+	'‘': "'", '’': "'", '“': "\"", '”': "\"", '–': "-", '—': "-", '…': "...", '•': "*",
+	'·': "-", '\u00a0': " ",
+}
+
+// asciiFallback returns text with every non-ASCII character transliterated,
+// or replaced with "?" if there is no ASCII equivalent, such as an emoji.
+func asciiFallback(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch replacement, ok := asciiReplacements[r]; {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case ok:
+			b.WriteString(replacement)
+		case r == '\ufe0f' || r == '\u200d':
+			// emoji variation selectors and joiners are part of the emoji
+			// already replaced
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// ASCIISender sends messages through Sender with their text, title and
+// message reduced to ASCII, for Slack-compatible endpoints that garble
+// Unicode. This is lossy: emoji and most non-Latin text become "?".
+type ASCIISender struct {
+	Sender MessageSender
+}
+
+func (s *ASCIISender) Send(msg SlackMessage) error {
+	msg.Text = asciiFallback(msg.Text)
+	if msg.Source != nil {
+		source := *msg.Source
+		source.Title = asciiFallback(source.Title)
+		source.Message = asciiFallback(source.Message)
+		msg.Source = &source
+	}
+	return s.Sender.Send(msg)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Batcher accumulates messages and hands them to flush as a single batch per
+// topic once the batch window has elapsed or max messages are pending,
+// whichever is first.
+type Batcher struct {
+	clock  Clock
+	window time.Duration
+	max    int
+	flush  func(topic string, texts []string, msgs []NtfyMessage)
+
+	mu      sync.Mutex
+	topics  []string
+	pending map[string]*batch
+	count   int
+	timer   Timer
+}
+
+// batch is the pending rendered texts for one topic, and the messages they
+// were rendered from.
+type batch struct {
+	texts []string
+	msgs  []NtfyMessage
+}
+
+// NewBatcher returns a Batcher flushing after window, or as soon as max
+// messages are pending. A max of 0 means only the window triggers a flush.
+func NewBatcher(clock Clock, window time.Duration, max int, flush func(topic string, texts []string, msgs []NtfyMessage)) *Batcher {
+	return &Batcher{
+		clock:   clock,
+		window:  window,
+		max:     max,
+		flush:   flush,
+		pending: make(map[string]*batch),
+	}
+}
+
+// Add queues text rendered from msg, starting the batch window if it is the
+// first pending message.
+func (b *Batcher) Add(msg NtfyMessage, text string) {
+	b.mu.Lock()
+	pending, ok := b.pending[msg.Topic]
+	if !ok {
+		pending = &batch{}
+		b.pending[msg.Topic] = pending
+		b.topics = append(b.topics, msg.Topic)
+	}
+	pending.texts = append(pending.texts, text)
+	pending.msgs = append(pending.msgs, msg)
+	b.count++
+	if b.max > 0 && b.count >= b.max {
+		topics, batches := b.take()
+		b.mu.Unlock()
+		b.flushAll(topics, batches)
+		return
+	}
+	if b.timer == nil {
+		b.timer = b.clock.AfterFunc(b.window, b.Flush)
+	}
+	b.mu.Unlock()
+}
+
+// Flush immediately hands any pending messages to flush.
+func (b *Batcher) Flush() {
+	b.mu.Lock()
+	topics, batches := b.take()
+	b.mu.Unlock()
+
+	b.flushAll(topics, batches)
+}
+
+func (b *Batcher) flushAll(topics []string, batches map[string]*batch) {
+	for _, topic := range topics {
+		b.flush(topic, batches[topic].texts, batches[topic].msgs)
+	}
+}
+
+// take empties the pending queue and stops the window timer. b.mu must be held.
+func (b *Batcher) take() ([]string, map[string]*batch) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	topics, batches := b.topics, b.pending
+	b.topics = nil
+	b.pending = make(map[string]*batch)
+	b.count = 0
+	return topics, batches
+}
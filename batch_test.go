@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// flushedBatch is one call of a Batcher's flush.
+type flushedBatch struct {
+	topic string
+	texts []string
+	ids   []string
+}
+
+// recordFlushes returns a Batcher flush function appending to flushed.
+func recordFlushes(flushed *[]flushedBatch) func(topic string, texts []string, msgs []NtfyMessage) {
+	return func(topic string, texts []string, msgs []NtfyMessage) {
+		var ids []string
+		for _, msg := range msgs {
+			ids = append(ids, msg.Id)
+		}
+		*flushed = append(*flushed, flushedBatch{topic, texts, ids})
+	}
+}
+
+func TestBatcherFlushesAfterWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	var flushed []flushedBatch
+	b := NewBatcher(clock, time.Minute, 0, recordFlushes(&flushed))
+
+	b.Add(NtfyMessage{Id: "a", Topic: "alerts"}, "disk full")
+	clock.Advance(30 * time.Second)
+	b.Add(NtfyMessage{Id: "b", Topic: "backups"}, "backup done")
+	b.Add(NtfyMessage{Id: "c", Topic: "alerts"}, "disk still full")
+	clock.Advance(29 * time.Second)
+	if len(flushed) != 0 {
+		t.Fatalf("flushed %+v before the window ended", flushed)
+	}
+
+	clock.Advance(time.Second)
+	want := []flushedBatch{
+		{"alerts", []string{"disk full", "disk still full"}, []string{"a", "c"}},
+		{"backups", []string{"backup done"}, []string{"b"}},
+	}
+	if !reflect.DeepEqual(flushed, want) {
+		t.Errorf("flushed %+v once the window ended, want %+v", flushed, want)
+	}
+
+	// the next message starts a new window
+	b.Add(NtfyMessage{Id: "d", Topic: "alerts"}, "disk ok")
+	clock.Advance(time.Minute)
+	if len(flushed) != 3 || flushed[2].ids[0] != "d" {
+		t.Errorf("flushed %+v, want a second batch for the message after the first", flushed)
+	}
+}
+
+func TestBatcherFlushesAtMax(t *testing.T) {
+	clock := &fakeClock{}
+	var flushed []flushedBatch
+	b := NewBatcher(clock, time.Minute, 2, recordFlushes(&flushed))
+
+	b.Add(NtfyMessage{Id: "a", Topic: "alerts"}, "one")
+	b.Add(NtfyMessage{Id: "b", Topic: "alerts"}, "two")
+	if len(flushed) != 1 || len(flushed[0].texts) != 2 {
+		t.Fatalf("flushed %+v with -batch-max messages pending, want one batch of 2", flushed)
+	}
+
+	clock.Advance(time.Minute)
+	if len(flushed) != 1 {
+		t.Errorf("flushed %+v after the window of a batch already flushed, want nothing more", flushed)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "time"
+
+// Clock tells the time, waits for it to pass and schedules calls. It is time
+// itself outside of tests, which substitute one they advance by hand.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// AfterFunc calls f in its own goroutine once d has passed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call scheduled by Clock.AfterFunc.
+type Timer interface {
+	// Stop cancels the call, and reports whether it was still to come.
+	Stop() bool
+}
+
+// realClock is the Clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                            { return time.Now() }
+func (realClock) Sleep(d time.Duration)                     { time.Sleep(d) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when Sleep or Advance is called, and
+// then calls the functions of the timers that are due, in the calling
+// goroutine.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	slept  []time.Duration
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	c.slept = append(c.slept, d)
+	c.mu.Unlock()
+	c.Advance(d)
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock on by d, and calls the timers due by then in the
+// order they are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due, later []*fakeTimer
+	for _, timer := range c.timers {
+		if timer.stopped {
+			continue
+		}
+		if timer.at.After(c.now) {
+			later = append(later, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+	c.timers = later
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	pending := !t.stopped && t.at.After(t.clock.now)
+	t.stopped = true
+	return pending
+}
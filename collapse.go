@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Collapser coalesces rapid updates to the same alert: the first message with
+// a given topic and title starts a window, later ones replace it, and only
+// the latest is handed to deliver when the window ends.
+type Collapser struct {
+	clock   Clock
+	window  time.Duration
+	deliver func(msg NtfyMessage)
+
+	mu      sync.Mutex
+	pending map[collapseKey]*collapsed
+}
+
+type collapseKey struct {
+	topic, title string
+}
+
+// collapsed is the latest message for a key, and the timer ending its window.
+type collapsed struct {
+	msg   NtfyMessage
+	timer Timer
+}
+
+// NewCollapser returns a Collapser with the given window.
+func NewCollapser(clock Clock, window time.Duration, deliver func(msg NtfyMessage)) *Collapser {
+	return &Collapser{
+		clock:   clock,
+		window:  window,
+		deliver: deliver,
+		pending: make(map[collapseKey]*collapsed),
+	}
+}
+
+// Add holds msg until its window ends, replacing any earlier message with the
+// same topic and title, which it returns. Messages without a title are
+// delivered immediately.
+func (c *Collapser) Add(msg NtfyMessage) (replaced NtfyMessage, ok bool) {
+	if msg.Title == "" {
+		c.deliver(msg)
+		return NtfyMessage{}, false
+	}
+
+	key := collapseKey{msg.Topic, msg.Title}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[key]; ok {
+		debugf("collapsing message %s into %s", pending.msg.Id, msg.Id)
+		replaced, pending.msg = pending.msg, msg
+		return replaced, true
+	}
+	c.pending[key] = &collapsed{
+		msg:   msg,
+		timer: c.clock.AfterFunc(c.window, func() { c.release(key) }),
+	}
+	return NtfyMessage{}, false
+}
+
+// release delivers the latest message for key, if its window is still open.
+func (c *Collapser) release(key collapseKey) {
+	c.mu.Lock()
+	pending, ok := c.pending[key]
+	delete(c.pending, key)
+	c.mu.Unlock()
+
+	if ok {
+		c.deliver(pending.msg)
+	}
+}
+
+// Flush immediately delivers the latest message for every open window.
+func (c *Collapser) Flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[collapseKey]*collapsed)
+	c.mu.Unlock()
+
+	for _, p := range pending {
+		p.timer.Stop()
+		c.deliver(p.msg)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactors hide the values of flags holding secrets when the configuration
+// is logged or printed.
+var redactors = map[string]func(string) string{
+	"ntfy-auth":             redactSecret,
+	"pagerduty-routing-key": redactSecret,
+	"slack-bot-token":       redactSecret,
+	"telegram-token":        redactSecret,
+	"slack-webhook":         redactUrlList,
+	"proxy-url":             redactUrl,
+	"output-webhook-url":    redactUrl,
+	"route":                 redactRoutes,
+	"priority-route":        redactRoutes,
+	"ntfy-header":           redactHeaders,
+}
+
+// nonConfigFlags are one-shot actions rather than configuration.
+var nonConfigFlags = map[string]bool{
+	"print-config":     true,
+	"test-message":     true,
+	"v":                true,
+	"version-detailed": true,
+}
+
+// configSetting is one effective configuration value, already redacted.
+// Configured is whether it was set by a flag or env var rather than left at
+// its default.
+type configSetting struct {
+	Name       string
+	Value      string
+	Configured bool
+}
+
+// configSettings returns every configuration flag's effective value, after
+// env var defaults have been applied, with secrets redacted.
+func configSettings() []configSetting {
+	configured := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		configured[f.Name] = true
+	})
+
+	var settings []configSetting
+	flag.VisitAll(func(f *flag.Flag) {
+		if nonConfigFlags[f.Name] {
+			return
+		}
+		if match := flagEnvVar.FindStringSubmatch(f.Usage); match != nil {
+			if _, ok := os.LookupEnv(match[1]); ok {
+				configured[f.Name] = true
+			}
+		}
+		value := f.Value.String()
+		if redact, ok := redactors[f.Name]; ok {
+			value = redact(value)
+		}
+		settings = append(settings, configSetting{Name: f.Name, Value: value, Configured: configured[f.Name]})
+	})
+	return settings
+}
+
+// configSummary describes the configured settings on a single line, quoting
+// each value so spaces, "=" and newlines in it can't be misread.
+func configSummary() string {
+	var b strings.Builder
+	for _, setting := range configSettings() {
+		if !setting.Configured {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%s=%q", setting.Name, setting.Value)
+	}
+	if b.Len() == 0 {
+		return "all defaults"
+	}
+	return b.String()
+}
+
+// configJSON describes the effective configuration as a JSON object.
+func configJSON() (string, error) {
+	values := make(map[string]string)
+	for _, setting := range configSettings() {
+		values[setting.Name] = setting.Value
+	}
+	out, err := json.MarshalIndent(values, "", "  ")
+	return string(out), err
+}
+
+// redactSecret masks a secret value, keeping only whether it is set.
+func redactSecret(secret string) string {
+	if secret == "" {
+		return "(not set)"
+	}
+	return "********"
+}
+
+// redactUrl reduces u to its scheme and host, hiding any credentials, path or query.
+func redactUrl(u string) string {
+	if u == "" {
+		return "(not set)"
+	}
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return "(invalid)"
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/..."
+}
+
+// redactUrlList redacts each URL in a comma-separated list.
+func redactUrlList(list string) string {
+	if list == "" {
+		return redactUrl(list)
+	}
+	urls := strings.Split(list, ",")
+	for i, u := range urls {
+		urls[i] = redactUrl(strings.TrimSpace(u))
+	}
+	return strings.Join(urls, ",")
+}
+
+// redactRoutes redacts the webhook URLs in a comma-separated topic=webhook_url list.
+func redactRoutes(list string) string {
+	if list == "" {
+		return ""
+	}
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		topic, webhook, _ := strings.Cut(entry, "=")
+		entries[i] = topic + "=" + redactUrl(webhook)
+	}
+	return strings.Join(entries, ",")
+}
+
+// redactHeader masks the value of a "Key: Value" header, which may well be a
+// token, keeping its name.
+func redactHeader(header string) string {
+	key, _, _ := strings.Cut(header, ":")
+	return key + ": " + redactSecret("set")
+}
+
+// redactHeaders redacts each header in a comma-separated list.
+func redactHeaders(list string) string {
+	if list == "" {
+		return ""
+	}
+	var headers []string
+	for _, header := range strings.Split(list, ",") {
+		// the rest of a value containing a comma has no name to keep
+		if strings.Contains(header, ":") {
+			headers = append(headers, redactHeader(header))
+		}
+	}
+	return strings.Join(headers, ",")
+}
+
+// configEnvPrefixes are the prefixes of env vars that look meant for this bot,
+// so any that aren't known are likely typos.
+var configEnvPrefixes = []string{"NTFY_", "SLACK_", "WEBHOOK_", "POST_PROCESS_", "TELEGRAM_", "MATTERMOST_", "PAGERDUTY_"}
+
+// flagEnvVar finds the env var named in a flag's usage text.
+var flagEnvVar = regexp.MustCompile(`value of the ([A-Z0-9_]+) env var`)
+
+// unknownEnvVars returns the env vars with a configEnvPrefixes prefix that no
+// flag reads, such as SLACK_WEBHOOK for SLACK_WEBHOOK_URL.
+func unknownEnvVars() []string {
+	known := make(map[string]bool)
+	flag.VisitAll(func(f *flag.Flag) {
+		if match := flagEnvVar.FindStringSubmatch(f.Usage); match != nil {
+			known[match[1]] = true
+		}
+	})
+
+	var unknown []string
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		for _, prefix := range configEnvPrefixes {
+			if strings.HasPrefix(key, prefix) && !known[key] {
+				unknown = append(unknown, key)
+				break
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestConfigSummary(t *testing.T) {
+	old := *slackPrefix
+	if err := flag.Set("slack-prefix", "a b=c\nd"); err != nil {
+		t.Fatal(err)
+	}
+	defer flag.Set("slack-prefix", old)
+	setFlag(t, ntfyAuth, "tk_secret")
+	t.Setenv("NTFY_AUTH", "tk_secret")
+
+	summary := configSummary()
+	for _, want := range []string{`slack-prefix="a b=c\nd"`, `ntfy-auth="********"`} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("configSummary() = %s, want it to contain %s", summary, want)
+		}
+	}
+	if strings.Contains(summary, "slack-max-length=") {
+		t.Errorf("configSummary() = %s, want settings left at their default left out", summary)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Cursor identifies the last message up to which every message has been
+// forwarded.
+type Cursor struct {
+	Id   string `json:"id"`
+	Time int64  `json:"time"`
+}
+
+// CursorStore persists the Cursor to a state file, so that after a restart the
+// subscription can resume with the messages that arrived in the meantime.
+// A ReadOnly store resumes from the state file but never updates it.
+//
+// Messages are tracked as they arrive and saved once handled, and the cursor
+// never moves past a tracked message that has not been saved yet, so one that
+// failed to send, or is still held for a batch or quiet hours, is received
+// again after a restart.
+type CursorStore struct {
+	Path     string
+	ReadOnly bool
+
+	mu      sync.Mutex
+	cursor  Cursor
+	pending []pendingMessage
+}
+
+// pendingMessage is a tracked message, and how many of its parts have not
+// been saved yet. Runs of saved messages are merged into their last one.
+type pendingMessage struct {
+	cursor      Cursor
+	outstanding int
+}
+
+// loadCursorStore reads the cursor saved at path. A missing or corrupt state
+// file is not an error: forwarding then starts from now.
+func loadCursorStore(path string) *CursorStore {
+	s := &CursorStore{Path: path}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("state file %s not found, starting from now\n", path)
+		return s
+	} else if err != nil {
+		fmt.Printf("bot error: reading state file %s: %s. starting from now\n", path, err)
+		return s
+	}
+
+	if err := json.Unmarshal(b, &s.cursor); err != nil || s.cursor.Id == "" {
+		fmt.Printf("bot error: state file %s is corrupt, starting from now\n", path)
+		s.cursor = Cursor{}
+	}
+	return s
+}
+
+// Since returns the ID of the last forwarded message, or "" if there is none.
+func (s *CursorStore) Since() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor.Id
+}
+
+// SinceTime returns the time of the last forwarded message, or 0 if there is
+// none.
+func (s *CursorStore) SinceTime() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor.Time
+}
+
+// Track records that msg has been received but not yet handled. Parts split
+// from one message share its ID, and are tracked together.
+func (s *CursorStore) Track(msg NtfyMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ReadOnly || msg.Id == "" {
+		return
+	}
+	if n := len(s.pending); n > 0 && s.pending[n-1].cursor.Id == msg.Id {
+		s.pending[n-1].outstanding++
+		return
+	}
+	s.pending = append(s.pending, pendingMessage{cursor: Cursor{Id: msg.Id, Time: msg.Time}, outstanding: 1})
+}
+
+// Save records that msg has been forwarded or deliberately skipped, advances
+// the cursor past every message handled before the oldest one still pending,
+// and writes it to the state file. A message that was never tracked advances
+// the cursor only when nothing is pending and no newer message has been saved.
+func (s *CursorStore) Save(msg NtfyMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ReadOnly || msg.Id == "" {
+		return
+	}
+
+	i := s.indexPending(msg.Id)
+	if i < 0 {
+		if len(s.pending) == 0 && msg.Time >= s.cursor.Time {
+			s.advance(Cursor{Id: msg.Id, Time: msg.Time})
+		}
+		return
+	}
+	if s.pending[i].outstanding--; s.pending[i].outstanding > 0 {
+		return
+	}
+
+	if i > 0 && s.pending[i-1].outstanding == 0 {
+		s.pending = append(s.pending[:i-1], s.pending[i:]...)
+		i--
+	}
+	if i+1 < len(s.pending) && s.pending[i+1].outstanding == 0 {
+		s.pending = append(s.pending[:i], s.pending[i+1:]...)
+	}
+	if s.pending[0].outstanding == 0 {
+		s.advance(s.pending[0].cursor)
+		s.pending = s.pending[1:]
+	}
+}
+
+// indexPending returns the index of the pending message with the given ID,
+// or -1. s.mu must be held.
+func (s *CursorStore) indexPending(id string) int {
+	for i, p := range s.pending {
+		if p.cursor.Id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// advance sets the cursor and writes it to the state file. s.mu must be held.
+func (s *CursorStore) advance(cursor Cursor) {
+	s.cursor = cursor
+	if err := writeFileAtomic(s.Path, s.cursor); err != nil {
+		fmt.Printf("bot error: writing state file %s: %s\n", s.Path, err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to path via a temporary file and rename,
+// so a crash never leaves a half-written file behind.
+func writeFileAtomic(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
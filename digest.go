@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Digester collects messages and hands them to send as one digest per topic
+// every interval, rather than forwarding them as they arrive. Unlike a
+// Batcher, it runs on a fixed schedule, however few or many messages arrive.
+type Digester struct {
+	clock    Clock
+	interval time.Duration
+	send     func(topic string, data DigestData)
+
+	mu      sync.Mutex
+	topics  []string
+	pending map[string][]NtfyMessage
+	since   time.Time
+}
+
+// DigestData is what the -digest-template is executed against: the messages
+// for one topic received between Since and Until, oldest first.
+type DigestData struct {
+	Topic    string
+	Since    time.Time
+	Until    time.Time
+	Messages []NtfyMessage
+	Vars     map[string]string
+}
+
+// Priority returns the messages of priority p, e.g. {{range .Priority 5}}.
+func (d DigestData) Priority(p int) []NtfyMessage {
+	var msgs []NtfyMessage
+	for _, msg := range d.Messages {
+		if msg.priority() == p {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// NewDigester starts a Digester sending a digest every interval.
+func NewDigester(clock Clock, interval time.Duration, send func(topic string, data DigestData)) *Digester {
+	d := &Digester{
+		clock:    clock,
+		interval: interval,
+		send:     send,
+		pending:  make(map[string][]NtfyMessage),
+		since:    clock.Now(),
+	}
+	d.clock.AfterFunc(interval, d.tick)
+	return d
+}
+
+// tick sends the scheduled digest and schedules the next one.
+func (d *Digester) tick() {
+	d.Flush()
+	d.clock.AfterFunc(d.interval, d.tick)
+}
+
+// Add holds msg for the next digest.
+func (d *Digester) Add(msg NtfyMessage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[msg.Topic]; !ok {
+		d.topics = append(d.topics, msg.Topic)
+	}
+	d.pending[msg.Topic] = append(d.pending[msg.Topic], msg)
+}
+
+// Flush immediately sends a digest for every topic with messages held, and
+// starts the next digest period.
+func (d *Digester) Flush() {
+	d.mu.Lock()
+	now := d.clock.Now()
+	topics, pending, since := d.topics, d.pending, d.since
+	d.topics = nil
+	d.pending = make(map[string][]NtfyMessage)
+	d.since = now
+	d.mu.Unlock()
+
+	for _, topic := range topics {
+		d.send(topic, DigestData{Topic: topic, Since: since, Until: now, Messages: pending[topic], Vars: templateVars})
+	}
+}
+
+// parseDigestTemplate parses the -digest-template and checks it executes
+// against an example digest.
+func parseDigestTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("digest-template").Funcs(templateFuncs).Option("missingkey=" + *templateMissingKey).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	example := NtfyMessage{Id: "example", Time: 1, Event: "message", Topic: "example", Title: "example", Message: "example"}
+	data := DigestData{Topic: "example", Since: time.Unix(0, 0), Until: time.Unix(1, 0), Messages: []NtfyMessage{example}, Vars: templateVars}
+	if err := tmpl.Execute(io.Discard, data); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// digestText renders a digest with tmpl, or if it is nil, as a count and a
+// line per message grouped by priority, most urgent first.
+func digestText(tmpl *template.Template, data DigestData) (string, error) {
+	var b strings.Builder
+	if tmpl != nil {
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("rendering %s: %w", tmpl.Name(), err)
+		}
+		return b.String(), nil
+	}
+
+	fmt.Fprintf(&b, "digest: %d messages since %s", len(data.Messages), data.Since.UTC().Format("2006-01-02 15:04 MST"))
+	for p := 5; p >= 1; p-- {
+		msgs := data.Priority(p)
+		if len(msgs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\npriority %d (%d):", p, len(msgs))
+		for _, msg := range msgs {
+			line := msg.Message
+			if msg.Title != "" {
+				line = msg.Title + ": " + line
+			}
+			first, _, _ := strings.Cut(line, "\n")
+			fmt.Fprintf(&b, "\n• %s", first)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDigesterFlushesEveryInterval(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clock := &fakeClock{now: start}
+	var digests []DigestData
+	d := NewDigester(clock, time.Hour, func(topic string, data DigestData) {
+		digests = append(digests, data)
+	})
+
+	d.Add(NtfyMessage{Id: "a", Topic: "alerts"})
+	d.Add(NtfyMessage{Id: "b", Topic: "alerts"})
+	clock.Advance(time.Hour - time.Second)
+	if len(digests) != 0 {
+		t.Fatalf("sent %d digests before the interval ended", len(digests))
+	}
+
+	clock.Advance(time.Second)
+	if len(digests) != 1 || len(digests[0].Messages) != 2 {
+		t.Fatalf("sent %+v after one interval, want one digest of 2 messages", digests)
+	}
+	if got := digests[0]; !got.Since.Equal(start) || !got.Until.Equal(start.Add(time.Hour)) {
+		t.Errorf("digest covers %s to %s, want %s to %s", got.Since, got.Until, start, start.Add(time.Hour))
+	}
+
+	// an interval without messages sends nothing, and the schedule carries on
+	clock.Advance(time.Hour)
+	d.Add(NtfyMessage{Id: "c", Topic: "alerts"})
+	clock.Advance(time.Hour)
+	if len(digests) != 2 || digests[1].Messages[0].Id != "c" {
+		t.Fatalf("sent %+v after three intervals, want a second digest of message c", digests)
+	}
+	if got := digests[1]; !got.Since.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("second digest starts at %s, want %s", got.Since, start.Add(2*time.Hour))
+	}
+}
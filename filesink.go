@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSender appends each message as a line of JSON to the file at Path, as
+// an audit log. Reopen lets the file be rotated away while the bot runs.
+type FileSender struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// fileRecord is one line of a FileSender's file.
+type fileRecord struct {
+	Time    string       `json:"time"`
+	Topic   string       `json:"topic"`
+	Id      string       `json:"id,omitempty"`
+	Text    string       `json:"text"`
+	Message *NtfyMessage `json:"message,omitempty"`
+}
+
+// NewFileSender opens path for appending, creating it if needed.
+func NewFileSender(path string) (*FileSender, error) {
+	s := &FileSender{Path: path}
+	if err := s.Reopen(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSender) Send(msg SlackMessage) error {
+	line, err := json.Marshal(fileRecord{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Topic:   msg.Topic,
+		Id:      msg.correlationId(),
+		Text:    msg.Text,
+		Message: msg.Source,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Reopen closes the file, if open, and opens Path again, so that after a log
+// rotator moves the file, new lines go to a fresh one.
+func (s *FileSender) Reopen() error {
+	file, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	return nil
+}
@@ -1,14 +1,3 @@
 module github.com/ozskywalker/ntfy-to-slack
 
 go 1.20
-
-require github.com/ashwanthkumar/slack-go-webhook v0.0.0-20200209025033-430dd4e66960
-
-require (
-	github.com/elazarl/goproxy v0.0.0-20221015165544-a0805db90819 // indirect
-	github.com/parnurzeal/gorequest v0.2.16 // indirect
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/smartystreets/goconvey v1.7.2 // indirect
-	golang.org/x/net v0.7.0 // indirect
-	moul.io/http2curl v1.0.0 // indirect
-)
@@ -0,0 +1,30 @@
+package main
+
+// InflightLimitedSender caps how many messages are being sent through Sender
+// at once. Sends can overlap when batches, digests, collapsed alerts or quiet
+// hours are flushed alongside the stream; beyond the cap they wait for a free
+// slot, which in turn holds up reading from ntfy rather than piling up.
+type InflightLimitedSender struct {
+	Sender MessageSender
+	slots  chan struct{}
+}
+
+// NewInflightLimitedSender wraps sender, allowing max concurrent sends.
+func NewInflightLimitedSender(sender MessageSender, max int) *InflightLimitedSender {
+	return &InflightLimitedSender{
+		Sender: sender,
+		slots:  make(chan struct{}, max),
+	}
+}
+
+func (s *InflightLimitedSender) Send(msg SlackMessage) error {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		debugf("%d sends in flight, waiting to send message for topic %s", cap(s.slots), msg.Topic)
+		s.slots <- struct{}{}
+	}
+	defer func() { <-s.slots }()
+
+	return s.Sender.Send(msg)
+}
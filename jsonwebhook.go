@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+)
+
+// JSONWebhookSender POSTs each message as JSON to an arbitrary endpoint. The
+// body is rendered by Template if set, otherwise it is a jsonWebhookBody.
+type JSONWebhookSender struct {
+	Url               string
+	Template          *template.Template
+	Client            *http.Client
+	UserAgent         string
+	CorrelationHeader bool
+}
+
+type jsonWebhookBody struct {
+	Topic   string       `json:"topic"`
+	Text    string       `json:"text"`
+	Message *NtfyMessage `json:"message,omitempty"`
+}
+
+func (s *JSONWebhookSender) Send(msg SlackMessage) error {
+	body, err := s.render(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+	if s.CorrelationHeader {
+		setCorrelationHeader(req, msg)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	debugf("output webhook %s responded for message %q with %s", redactUrl(s.Url), msg.correlationId(), resp.Status)
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("output webhook rejected message: %s %s", resp.Status, respBody)
+	}
+	return nil
+}
+
+func (s *JSONWebhookSender) render(msg SlackMessage) ([]byte, error) {
+	if s.Template == nil {
+		return json.Marshal(jsonWebhookBody{Topic: msg.Topic, Text: msg.Text, Message: msg.Source})
+	}
+
+	return renderJSONTemplate(s.Template, msg)
+}
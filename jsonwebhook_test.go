@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONWebhookSenderSendsMessageId(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	s := &JSONWebhookSender{Url: server.URL, Client: server.Client()}
+	source := NtfyMessage{Id: "abc123", Time: 1, Event: "message", Topic: "alerts", Message: "disk full"}
+	if err := s.Send(SlackMessage{Topic: "alerts", Text: "disk full", Source: &source}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Message map[string]any `json:"message"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("webhook body %s is not JSON: %s", body, err)
+	}
+	if got.Message["id"] != "abc123" {
+		t.Errorf("webhook body %s has message id %v, want \"abc123\"", body, got.Message["id"])
+	}
+}
+
+func TestJSONWebhookSenderTemplatePrefixSuffix(t *testing.T) {
+	setFlag(t, slackPrefix, ":satellite: [prod]")
+	setFlag(t, slackSuffix, "(eu)")
+	setFlag(t, showTopic, false)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	tmpl, err := parseTemplate("output-template", `{"alert": {{json .Text}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useSender(t, &JSONWebhookSender{Url: server.URL, Template: tmpl, Client: server.Client()})
+	deliverMessage(NtfyMessage{Id: "a", Time: 1, Event: "message", Topic: "alerts", Title: "disk", Message: "full"})
+
+	var got struct {
+		Alert string `json:"alert"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("webhook body %s is not JSON: %s", body, err)
+	}
+	if want := ":satellite: [prod] disk: full (eu)"; got.Alert != want {
+		t.Errorf("-output-template rendered .Text as %q, want %q", got.Alert, want)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+// mattermostMessage is a Mattermost incoming webhook payload: Slack's, plus
+// optional overrides that Mattermost honours when the webhook allows them.
+type mattermostMessage struct {
+	Text     string `json:"text"`
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+	IconUrl  string `json:"icon_url,omitempty"`
+}
+
+// mattermostPayload returns a WebhookSender payload builder that posts each
+// message with the configured -mattermost-* overrides.
+func mattermostPayload(msg SlackMessage) (any, error) {
+	return mattermostMessage{
+		Text:     msg.Text,
+		Channel:  *mattermostChannel,
+		Username: *mattermostUsername,
+		IconUrl:  *mattermostIconUrl,
+	}, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdCodeRegexp   = regexp.MustCompile("(?s)```.*?```|`[^`\n]+`")
+	mdLinkRegexp   = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+	mdBoldRegexp   = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	mdItalicRegexp = regexp.MustCompile(`\*([^*\s](?:[^*\n]*[^*\s])?)\*`)
+	mdStrikeRegexp = regexp.MustCompile(`~~([^~\n]+)~~`)
+)
+
+// boldMarker temporarily stands in for converted bold so the italic pass
+// doesn't pick it up again.
+const boldMarker = "\x00"
+
+// markdownToMrkdwn converts common Markdown (bold, italic, strikethrough and
+// links) to Slack mrkdwn. Inline code and code blocks are left untouched, as
+// is anything it doesn't recognise.
+func markdownToMrkdwn(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range mdCodeRegexp.FindAllStringIndex(text, -1) {
+		b.WriteString(convertMarkdownSpan(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(convertMarkdownSpan(text[last:]))
+	return b.String()
+}
+
+// convertMarkdownSpan converts a span of text known not to contain code.
+func convertMarkdownSpan(text string) string {
+	text = mdLinkRegexp.ReplaceAllString(text, "<$2|$1>")
+	text = mdBoldRegexp.ReplaceAllString(text, boldMarker+"$1$2"+boldMarker)
+	text = mdItalicRegexp.ReplaceAllString(text, "_${1}_")
+	text = mdStrikeRegexp.ReplaceAllString(text, "~$1~")
+	return strings.ReplaceAll(text, boldMarker, "*")
+}
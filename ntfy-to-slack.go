@@ -3,46 +3,921 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime/debug"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
-
-	slack "github.com/ashwanthkumar/slack-go-webhook"
+	"unicode/utf8"
 )
 
-const VERSION = "v1.2 2023-03-01"
 const UpstreamNtfyServer = "ntfy.sh"
 
+// reconnectDelay is how long to wait before reconnecting to ntfy after a
+// failed connection attempt or a closed stream.
+const reconnectDelay = 30 * time.Second
+
+// failoverAttempts is how many consecutive connection failures to one of
+// several -ntfy-domain servers it takes to fail over to the next.
+const failoverAttempts = 3
+
 var defaultNtfyDomain = UpstreamNtfyServer
 var ntfyDomain *string
 var ntfyTopic *string
+var ntfyBasePath *string
 var ntfyAuth *string
+var ntfyAuthFile *string
+var exitOnAuthFailure *bool
+var ntfyCaCert *string
+var ntfyClientCert *string
+var ntfyClientKey *string
+var ntfyHeaders listFlag
+var ntfySince *string
+var poll *bool
+var stateFile *string
+var maxRuntime *time.Duration
+var maxConnectAttempts *int
+var logKeepalives *bool
+var maxLineSize *int
+var ackActionsEnabled *bool
+var ntfyInsecureSkipVerify *bool
+var proxyUrl *string
+var userAgent *string
+var correlationHeader *bool
 var slackWebhookUrl *string
+var slackWebhookUrlFile *string
+var strictSlackUrl *bool
+var dryRun *bool
+var debugMode *bool
+var showMessageId *bool
+var showTopic *bool
+var allowEmpty *bool
+var splitOnNewline *bool
+var splitTitle *string
+var slackMaxLength *int
+var slackRateLimit *float64
+var maxInflight *int
+var slackCodeblock *bool
+var heartbeatInterval *time.Duration
+var heartbeatMessage *string
+var asciiFallbackEnabled *bool
+var suppressConsecutiveDuplicates *bool
+var slackTimeout *time.Duration
+var slackMrkdwn *bool
+var noMarkdown *bool
+var noRecover *bool
+var testMessage *string
+var strictEnv *bool
+var printConfig *string
+var version *bool
+var versionDetail *bool
+var slackFormat *string
+var slackPayloadTemplateText *string
+var slackPrefix *string
+var slackLinkNames *bool
+var slackTextField *string
+var slackFooter *bool
+var showMetadata *bool
+var slackSuffix *string
+var tagPlacement *string
+var batchWindow *time.Duration
+var batchMax *int
+var digestInterval *time.Duration
+var digestTemplateText *string
+var collapseWindow *time.Duration
+var queueSize *int
+var queueFullPolicy *string
+var routes = routeFlag{}
+var priorityRoutes = routeFlag{}
+var minPriority *int
+var topicAllow listFlag
+var topicDeny listFlag
+var quietHoursWindow *string
+var quietTimezone *string
+var quietMinPriority *int
+var templateVars = keyValueFlag{}
+var workflowVars = keyValueFlag{}
+var mentionMap = keyValueFlag{}
+var templateFor = keyValueFlag{}
+var templateMissingKey *string
+
+var output *string
+var pagerDutyRoutingKey *string
+var telegramToken *string
+var telegramChatId *string
+var telegramMarkdown *bool
+var outputWebhookUrl *string
+var outputTemplate *string
+var outputFile *string
+var slackBotToken *string
+var slackChannel *string
+var slackThreadKey *string
+var mattermostChannel *string
+var mattermostUsername *string
+var mattermostIconUrl *string
+var postProcessExec listFlag
+var postProcessTimeout *time.Duration
 
+var sender MessageSender
+var postProcessor PostProcessor
+var threadKeyTemplate *template.Template
+var slackPayloadTemplate *template.Template
+var digestTemplate *template.Template
+var batcher *Batcher
+var digester *Digester
+var quietHours *QuietHours
+var collapser *Collapser
+var messageQueue *MessageQueue
+var cursorStore *CursorStore
+var actionClient *http.Client
+
+// NtfyMessage is a single event from the ntfy JSON stream, tagged to match ntfy's wire format.
 type NtfyMessage struct {
-	Id      string
-	Time    int64
-	Event   string
-	Topic   string
-	Title   string
-	Message string
+	Id          string      `json:"id"`
+	Time        int64       `json:"time"`
+	Event       string      `json:"event"`
+	Topic       string      `json:"topic"`
+	Title       string      `json:"title,omitempty"`
+	Message     string      `json:"message,omitempty"`
+	Priority    int         `json:"priority,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+	Attachment  *Attachment `json:"attachment,omitempty"`
+	Actions     []Action    `json:"actions,omitempty"`
 }
 
-func sendToSlack(message string) {
-	payload := slack.Payload{
-		Text: "(" + *ntfyTopic + ") " + message,
+// defaultPriority is the ntfy priority of messages published without one.
+// ntfy omits it from its JSON.
+const defaultPriority = 3
+
+// priority returns the message's ntfy priority from 1 (min) to 5 (max).
+func (m NtfyMessage) priority() int {
+	if m.Priority == 0 {
+		return defaultPriority
 	}
+	return m.Priority
+}
 
-	if err := slack.Send(*slackWebhookUrl, "", payload); len(err) > 0 {
-		log.Panic("sendToSlack: something went wrong", err[0])
+// Attachment is a file attached to an ntfy message.
+type Attachment struct {
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Expires int64  `json:"expires,omitempty"`
+	URL     string `json:"url"`
+}
+
+// debugf prints a log line only when -debug is set.
+func debugf(format string, args ...any) {
+	if *debugMode {
+		fmt.Printf("debug: "+format+"\n", args...)
 	}
 }
 
-func main() {
+// defaultUserAgent returns the User-Agent identifying this build, e.g. ntfy-to-slack/v1.2.
+func defaultUserAgent() string {
+	return "ntfy-to-slack/" + Version
+}
+
+// formatMessage renders an ntfy message as the text posted to Slack.
+func formatMessage(msg NtfyMessage) string {
+	body := msg.Message
+	if *slackCodeblock && body != "" {
+		body = codeBlock(body)
+	}
+
+	var text string
+	switch {
+	case msg.Title == "":
+		text = body
+	case msg.Message == "":
+		text = msg.Title
+	case *slackFormat == "attachment":
+		// the attachment shows the title on its own
+		text = body
+	case *slackCodeblock:
+		text = msg.Title + "\n" + body
+	default:
+		text = msg.Title + ": " + body
+	}
+	if *slackMrkdwn && msg.ContentType == "text/markdown" && !*slackCodeblock {
+		text = markdownToMrkdwn(text)
+	}
+	if len(msg.Tags) > 0 {
+		tags := "[" + strings.Join(msg.Tags, ", ") + "]"
+		switch *tagPlacement {
+		case "prefix":
+			text = tags + " " + text
+		case "suffix":
+			text += " " + tags
+		}
+	}
+	if msg.Attachment != nil && msg.Attachment.URL != "" {
+		name := msg.Attachment.Name
+		if name == "" {
+			name = msg.Attachment.URL
+		}
+		if *noMarkdown {
+			text += "\n" + name + ": " + msg.Attachment.URL
+		} else if *output == "mattermost" {
+			text += "\n[" + name + "](" + msg.Attachment.URL + ")"
+		} else {
+			text += "\n<" + msg.Attachment.URL + "|" + name + ">"
+		}
+		if msg.Attachment.Expires > 0 {
+			text += " (link expires " + time.Unix(msg.Attachment.Expires, 0).UTC().Format("2006-01-02 15:04 MST") + ")"
+		}
+	}
+	if *showMessageId && msg.Id != "" {
+		text += " (id: " + msg.Id + ")"
+	}
+	return text
+}
+
+// codeBlock wraps text in a Slack code block, for -slack-codeblock. Triple
+// backticks in text would end the block early, so they are broken up with
+// zero-width spaces.
+func codeBlock(text string) string {
+	return "```\n" + strings.ReplaceAll(text, "```", "`\u200b`\u200b`") + "\n```"
+}
+
+func newSlackMessage(topic string, message string) SlackMessage {
+	text := message
+	if *showTopic {
+		text = literalText("("+topic+")") + " " + message
+	}
+	if *slackPrefix != "" {
+		text = *slackPrefix + " " + text
+	}
+	if *slackSuffix != "" {
+		text += " " + *slackSuffix
+	}
+	// attachments have a footer of their own
+	if *slackFooter && *slackFormat != "attachment" {
+		text += "\n— " + literalText(footerText(topic))
+	}
+	if truncated, ok := truncateText(text, *slackMaxLength, escapingMarkdownV2()); ok {
+		fmt.Printf("warning: message for topic %s is %d characters, truncating to %d\n", topic, utf8.RuneCountInString(text), *slackMaxLength)
+		text = truncated
+	}
+
+	return SlackMessage{
+		Topic:     topic,
+		Text:      text,
+		LinkNames: *slackLinkNames,
+	}
+}
+
+// metadataLine summarises where and when msg was published, for -show-metadata,
+// e.g. "alerts · priority 4 · backup, disk · 2024-01-02 15:04 UTC".
+func metadataLine(msg NtfyMessage) string {
+	parts := []string{msg.Topic, "priority " + strconv.Itoa(msg.priority())}
+	if len(msg.Tags) > 0 {
+		parts = append(parts, strings.Join(msg.Tags, ", "))
+	}
+	parts = append(parts, time.Unix(msg.Time, 0).UTC().Format("2006-01-02 15:04 MST"))
+	return strings.Join(parts, " · ")
+}
+
+// footerText credits the bot and topic a message came from, for -slack-footer.
+func footerText(topic string) string {
+	return fmt.Sprintf("forwarded by ntfy-to-slack %s from topic '%s'", Version, topic)
+}
+
+// literalText escapes text the bot writes itself, as opposed to the output of
+// a template or post-processor, for outputs that parse markup: with
+// -telegram-markdown, Telegram rejects messages with unescaped reserved
+// characters, which includes the "." of every domain name.
+func literalText(text string) string {
+	if escapingMarkdownV2() {
+		return escapeMarkdownV2(text)
+	}
+	return text
+}
+
+// escapingMarkdownV2 reports whether text is sent as Telegram MarkdownV2.
+func escapingMarkdownV2() bool {
+	return *output == "telegram" && *telegramMarkdown
+}
+
+func sendToSlack(topic string, message string) error {
+	return sendSlackMessage(newSlackMessage(topic, message))
+}
+
+// sendSlackMessage sends msg, logging and returning the error if it fails.
+// Failed sends are not retried: the message is dropped, and callers must not
+// acknowledge it or save it as the -state-file cursor.
+func sendSlackMessage(msg SlackMessage) error {
+	err := sender.Send(msg)
+	if err != nil {
+		if id := msg.correlationId(); id != "" {
+			fmt.Printf("bot error: sending message %s for topic %s failed, dropping it: %s\n", id, msg.Topic, err)
+		} else {
+			fmt.Printf("bot error: sending message for topic %s failed, dropping it: %s\n", msg.Topic, err)
+		}
+	}
+	return err
+}
+
+// threadKey renders the -slack-thread-key template for msg, or returns "" if
+// threading is off or the template fails.
+func threadKey(msg NtfyMessage) string {
+	if threadKeyTemplate == nil {
+		return ""
+	}
+
+	var key strings.Builder
+	if err := threadKeyTemplate.Execute(&key, newTemplateData(msg, "")); err != nil {
+		fmt.Printf("slack-thread-key error for message %s: %s. sending without a thread\n", msg.Id, err)
+		return ""
+	}
+	return key.String()
+}
+
+// forwardMessage renders an ntfy message and sends it to Slack, or queues it
+// for the next batch when batching is enabled.
+func forwardMessage(msg NtfyMessage) {
+	if !topicAllowed(msg.Topic) {
+		debugf("skipping message %s: topic %s is not allowed by -topic-allow/-topic-deny", msg.Id, msg.Topic)
+		markHandled(msg)
+		return
+	}
+	if msg.priority() < *minPriority {
+		debugf("skipping message %s: priority %d is below -min-priority %d", msg.Id, msg.priority(), *minPriority)
+		markHandled(msg)
+		return
+	}
+	if *suppressConsecutiveDuplicates && isConsecutiveDuplicate(msg) {
+		debugf("skipping message %s: same title and message as the last one forwarded", msg.Id)
+		markHandled(msg)
+		return
+	}
+	if quietHours != nil && quietHours.Hold(msg, time.Now()) {
+		debugf("holding message %s until quiet hours end", msg.Id)
+		return
+	}
+	if collapser != nil {
+		if replaced, ok := collapser.Add(msg); ok {
+			markHandled(replaced)
+		}
+		return
+	}
+
+	deliverMessage(msg)
+}
+
+// lastForwarded is the title and message of the last message forwarded, for
+// -suppress-consecutive-duplicates. Messages are forwarded one at a time, by
+// the stream or the queue worker.
+var lastForwarded struct {
+	seen           bool
+	title, message string
+}
+
+// isConsecutiveDuplicate reports whether msg has the same title and message as
+// the last message forwarded, and remembers it as the last otherwise.
+func isConsecutiveDuplicate(msg NtfyMessage) bool {
+	if lastForwarded.seen && lastForwarded.title == msg.Title && lastForwarded.message == msg.Message {
+		return true
+	}
+	lastForwarded.seen = true
+	lastForwarded.title, lastForwarded.message = msg.Title, msg.Message
+	return false
+}
+
+// deliverMessage renders msg and sends it, or adds it to the pending batch or
+// digest.
+func deliverMessage(msg NtfyMessage) {
+	if digester != nil {
+		digester.Add(msg)
+		return
+	}
+	text := renderMessage(msg)
+	if strings.TrimSpace(text) == "" && !*allowEmpty {
+		debugf("skipping message %s: nothing to send", msg.Id)
+		markHandled(msg)
+		return
+	}
+	// attachments show it in their footer instead
+	if *showMetadata && *slackFormat != "attachment" {
+		text += "\n" + literalText(metadataLine(msg))
+	}
+
+	if batcher != nil {
+		batcher.Add(msg, text)
+		return
+	}
+
+	slackMsg := newSlackMessage(msg.Topic, text)
+	slackMsg.ThreadKey = threadKey(msg)
+	slackMsg.Source = &msg
+	if err := sendSlackMessage(slackMsg); err != nil {
+		return
+	}
+	if actionClient != nil {
+		ackActions(actionClient, msg)
+	}
+	markHandled(msg)
+}
+
+// markHandled records in the -state-file cursor that msg has been forwarded
+// or deliberately skipped. A message that failed to send is never marked, so
+// the cursor stays behind it.
+func markHandled(msg NtfyMessage) {
+	if cursorStore != nil {
+		cursorStore.Save(msg)
+	}
+}
+
+// topicAllowed reports whether messages from topic should be forwarded: it
+// must not be in -topic-deny and, if -topic-allow is set, must be in it.
+func topicAllowed(topic string) bool {
+	if topicDeny.contains(topic) {
+		return false
+	}
+	return len(topicAllow) == 0 || topicAllow.contains(topic)
+}
+
+// newSender returns the sender for the configured -output.
+func newSender(client *http.Client) (MessageSender, error) {
+	switch *output {
+	case "slack":
+		if *slackBotToken != "" {
+			var s MessageSender = &APISender{Token: *slackBotToken, Channel: *slackChannel, Client: client, UserAgent: *userAgent}
+			if *slackRateLimit > 0 {
+				s = NewRateLimitedSender(s, *slackRateLimit)
+			}
+			return s, nil
+		}
+		return newWebhookSenders(client), nil
+	case "mattermost":
+		for _, u := range webhookUrls() {
+			if err := validateWebhookUrl(u); err != nil {
+				return nil, fmt.Errorf("-output=mattermost: %w", err)
+			}
+		}
+		return newWebhookSenders(client), nil
+	case "slack-workflow":
+		urls := webhookUrls()
+		if len(urls) == 0 {
+			return nil, errors.New("-output=slack-workflow requires -slack-webhook")
+		}
+		if len(workflowVars) == 0 {
+			return nil, errors.New("-output=slack-workflow requires -workflow-var")
+		}
+		vars, err := parseWorkflowVars(workflowVars)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -workflow-var: %w", err)
+		}
+		fanOut := &FanOutSender{}
+		for _, u := range urls {
+			s, err := newWorkflowSender(u, vars, client)
+			if err != nil {
+				return nil, err
+			}
+			fanOut.Senders = append(fanOut.Senders, s)
+		}
+		if len(fanOut.Senders) == 1 {
+			return fanOut.Senders[0], nil
+		}
+		return fanOut, nil
+	case "pagerduty":
+		if *pagerDutyRoutingKey == "" {
+			return nil, errors.New("-output=pagerduty requires -pagerduty-routing-key")
+		}
+		return &PagerDutySender{RoutingKey: *pagerDutyRoutingKey, Client: client, UserAgent: *userAgent}, nil
+	case "telegram":
+		if *telegramToken == "" || *telegramChatId == "" {
+			return nil, errors.New("-output=telegram requires -telegram-token and -telegram-chat-id")
+		}
+		s := &TelegramSender{Token: *telegramToken, ChatId: *telegramChatId, Client: client, UserAgent: *userAgent}
+		if *telegramMarkdown {
+			s.ParseMode = "MarkdownV2"
+		}
+		return s, nil
+	case "file":
+		if *outputFile == "" {
+			return nil, errors.New("-output=file requires -output-file")
+		}
+		return NewFileSender(*outputFile)
+	case "webhook":
+		return newJSONWebhookSender(*outputWebhookUrl, client)
+	default:
+		return nil, fmt.Errorf("invalid -output %q: expected slack, slack-workflow, mattermost, pagerduty, telegram, webhook or file", *output)
+	}
+}
+
+// newRouteSender returns the sender for a -route or -priority-route webhook
+// u, posting in the format of the configured -output.
+func newRouteSender(u string, client *http.Client) (MessageSender, error) {
+	switch *output {
+	case "slack":
+		return newWebhookSender(u, client), nil
+	case "mattermost":
+		if err := validateWebhookUrl(u); err != nil {
+			return nil, fmt.Errorf("-output=mattermost: %w", err)
+		}
+		return newWebhookSender(u, client), nil
+	case "slack-workflow":
+		vars, err := parseWorkflowVars(workflowVars)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -workflow-var: %w", err)
+		}
+		return newWorkflowSender(u, vars, client)
+	case "webhook":
+		return newJSONWebhookSender(u, client)
+	default:
+		return nil, fmt.Errorf("-output=%s does not post to webhooks, so it cannot be routed", *output)
+	}
+}
+
+// newWorkflowSender returns a sender starting the workflow behind webhook u,
+// rate limited per -slack-rate-limit.
+func newWorkflowSender(u string, vars map[string]*template.Template, client *http.Client) (MessageSender, error) {
+	if err := validateWebhookUrl(u); err != nil {
+		return nil, fmt.Errorf("-output=slack-workflow: %w", err)
+	}
+	var s MessageSender = &WorkflowSender{Url: u, Vars: vars, Client: client, UserAgent: *userAgent, CorrelationHeader: *correlationHeader}
+	if *slackRateLimit > 0 {
+		s = NewRateLimitedSender(s, *slackRateLimit)
+	}
+	return s, nil
+}
+
+// newJSONWebhookSender returns a sender POSTing JSON to u for -output=webhook,
+// rendered by the -output-template if it is set.
+func newJSONWebhookSender(u string, client *http.Client) (MessageSender, error) {
+	if err := validateWebhookUrl(u); err != nil {
+		return nil, fmt.Errorf("-output=webhook: %w", err)
+	}
+	s := &JSONWebhookSender{Url: u, Client: client, UserAgent: *userAgent, CorrelationHeader: *correlationHeader}
+	if *outputTemplate != "" {
+		tmpl, err := parseTemplate("output-template", *outputTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -output-template: %w", err)
+		}
+		s.Template = tmpl
+	}
+	return s, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(list string) []string {
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// webhookUrls splits the comma-separated -slack-webhook into its URLs.
+func webhookUrls() []string {
+	return splitList(*slackWebhookUrl)
+}
+
+// newWebhookSenders returns a sender for -slack-webhook, fanning out to every
+// webhook when more than one is given.
+func newWebhookSenders(client *http.Client) MessageSender {
+	urls := webhookUrls()
+	switch len(urls) {
+	case 0:
+		return newWebhookSender(*slackWebhookUrl, client)
+	case 1:
+		return newWebhookSender(urls[0], client)
+	}
+
+	fanOut := &FanOutSender{}
+	for _, u := range urls {
+		fanOut.Senders = append(fanOut.Senders, newWebhookSender(u, client))
+	}
+	return fanOut
+}
+
+// newWebhookSender returns a sender for the Slack (or, with -output=mattermost,
+// Mattermost) webhook u, rate limited per -slack-rate-limit.
+func newWebhookSender(u string, client *http.Client) MessageSender {
+	var s MessageSender = &WebhookSender{Url: u, Client: client, UserAgent: *userAgent, Payload: webhookPayload(), CorrelationHeader: *correlationHeader}
+	if *slackRateLimit > 0 {
+		s = NewRateLimitedSender(s, *slackRateLimit)
+	}
+	return s
+}
+
+// webhookPayload returns the builder for the configured webhook payload, or
+// nil to send the plain Slack message.
+func webhookPayload() func(msg SlackMessage) (any, error) {
+	if slackPayloadTemplate != nil {
+		return slackTemplatePayload
+	} else if *output == "mattermost" {
+		return mattermostPayload
+	} else if *slackFormat == "attachment" {
+		return slackAttachmentPayload
+	}
+	return nil
+}
+
+// lookupEnvString returns the value of the env var key, or def if it is unset.
+func lookupEnvString(key string, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// lookupEnvBool returns the boolean value of the env var key, or def if it is unset or unparsable.
+func lookupEnvBool(key string, def bool) bool {
+	if v, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// proxyFunc returns the proxy selection used by all outbound HTTP clients:
+// proxyUrl if it is set, otherwise HTTP_PROXY/HTTPS_PROXY/NO_PROXY from the environment.
+func proxyFunc(proxyUrl string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyUrl == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	parsed, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %q: %w", proxyUrl, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy url %q: scheme must be http, https or socks5", proxyUrl)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q: missing host", proxyUrl)
+	}
+	return http.ProxyURL(parsed), nil
+}
+
+// newTransport returns the connection-pooling transport shared by outbound
+// clients; each client sets its own timeout. It is a variable so tests can
+// substitute their own transport.
+var newTransport = func(proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          10,
+		MaxIdleConnsPerHost:   2,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// validateWebhookUrl checks that u is an absolute HTTPS URL.
+func validateWebhookUrl(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url %q: %w", u, err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook url %q: must be an https:// URL", u)
+	}
+	return nil
+}
+
+var slackWebhookPath = regexp.MustCompile(`^/services/T[A-Z0-9]+/B[A-Z0-9]+/[A-Za-z0-9]+$`)
+
+// validateSlackWebhookUrl checks that u looks like a real Slack incoming
+// webhook, to catch typos that would otherwise only show up as failed sends.
+func validateSlackWebhookUrl(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Scheme != "https" || parsed.Host != "hooks.slack.com" || !slackWebhookPath.MatchString(parsed.Path) {
+		return fmt.Errorf("%s is not a Slack incoming webhook: expected https://hooks.slack.com/services/T.../B.../...", redactUrl(u))
+	}
+	return nil
+}
+
+// routeFlag collects repeatable topic=webhook_url routing entries.
+type routeFlag map[string]string
+
+func (r routeFlag) String() string {
+	routes := make([]string, 0, len(r))
+	for topic, webhook := range r {
+		routes = append(routes, topic+"="+webhook)
+	}
+	sort.Strings(routes)
+	return strings.Join(routes, ",")
+}
+
+func (r routeFlag) Set(value string) error {
+	topic, webhook, ok := strings.Cut(value, "=")
+	if !ok || topic == "" {
+		return fmt.Errorf("invalid route %q: expected topic=webhook_url", value)
+	}
+	if err := validateWebhookUrl(webhook); err != nil {
+		return fmt.Errorf("invalid route for topic %q: %w", topic, err)
+	}
+	r[topic] = webhook
+	return nil
+}
+
+// keyValueFlag collects repeatable key=value entries.
+type keyValueFlag map[string]string
+
+func (kv keyValueFlag) String() string {
+	entries := make([]string, 0, len(kv))
+	for key, value := range kv {
+		entries = append(entries, key+"="+value)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func (kv keyValueFlag) Set(entry string) error {
+	key, value, ok := strings.Cut(entry, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid entry %q: expected key=value", entry)
+	}
+	kv[key] = value
+	return nil
+}
+
+// listFlag collects repeatable values in the order they were given.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// contains reports whether value was given.
+func (l listFlag) contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// setFromEnvList applies each comma-separated entry of the env var key to f,
+// as though it had been passed as a repeated flag.
+func setFromEnvList(f flag.Value, key string) {
+	if list, ok := os.LookupEnv(key); ok && list != "" {
+		for _, entry := range strings.Split(list, ",") {
+			if err := f.Set(strings.TrimSpace(entry)); err != nil {
+				log.Fatalf("%s: %s", key, err)
+			}
+		}
+	}
+}
+
+// lookupEnvInt returns the integer value of the env var key, or def if it is unset or unparsable.
+func lookupEnvInt(key string, def int) int {
+	if v, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return def
+}
+
+// lookupEnvFloat returns the float value of the env var key, or def if it is unset or unparsable.
+func lookupEnvFloat(key string, def float64) float64 {
+	if v, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// lookupEnvDuration returns the duration value of the env var key, or def if it is unset or unparsable.
+func lookupEnvDuration(key string, def time.Duration) time.Duration {
+	if v, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+// readSecretFile reads a secret, such as a mounted Docker secret, from path
+// with surrounding whitespace and newlines trimmed.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// resolveSecretFile replaces *value with the contents of file if file is set.
+// Setting both value and file is an error.
+func resolveSecretFile(value *string, file string, valueName string, fileName string) error {
+	if file == "" {
+		return nil
+	}
+	if *value != "" {
+		return fmt.Errorf("only one of %s and %s may be set", valueName, fileName)
+	}
+
+	secret, err := readSecretFile(file)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fileName, err)
+	}
+	*value = secret
+	return nil
+}
+
+// reopenOnHangup reopens the output file on SIGHUP, for log rotation.
+func reopenOnHangup(s *FileSender) {
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			if err := s.Reopen(); err != nil {
+				fmt.Printf("bot error: reopening %s: %s\n", s.Path, err)
+				continue
+			}
+			fmt.Printf("received SIGHUP, reopened %s\n", s.Path)
+		}
+	}()
+}
+
+// defaultHeartbeatMessage is the -heartbeat-message unless one is given.
+const defaultHeartbeatMessage = "heartbeat: ntfy-to-slack is running, subscribed to {{.Topic}}"
+
+// startHeartbeat posts tmpl to Slack every interval through the usual sender.
+// Heartbeats are logged as such, and failures are logged rather than fatal.
+// The heartbeat is the bot's own text, so it is escaped like its notices.
+func startHeartbeat(interval time.Duration, tmpl *template.Template) {
+	go func() {
+		for range time.Tick(interval) {
+			var text strings.Builder
+			msg := NtfyMessage{Topic: *ntfyTopic, Time: time.Now().Unix()}
+			if err := tmpl.Execute(&text, newTemplateData(msg, "")); err != nil {
+				fmt.Printf("bot error: heartbeat-message failed: %s\n", err)
+				continue
+			}
+			if err := sender.Send(newSlackMessage(*ntfyTopic, literalText(text.String()))); err != nil {
+				fmt.Printf("bot error: sending heartbeat: %s\n", err)
+				continue
+			}
+			fmt.Printf("heartbeat sent to Slack\n")
+		}
+	}()
+}
+
+// shutdownMu ensures only one shutdown runs; it is never unlocked.
+var shutdownMu sync.Mutex
+
+// shutdown flushes any pending batched messages and exits with code.
+func shutdown(code int) {
+	shutdownMu.Lock()
+	if messageQueue != nil {
+		messageQueue.Drain()
+	}
+	if quietHours != nil {
+		quietHours.Flush()
+	}
+	if collapser != nil {
+		collapser.Flush()
+	}
+	if digester != nil {
+		digester.Flush()
+	}
+	if batcher != nil {
+		batcher.Flush()
+	}
+	os.Exit(code)
+}
+
+// defineFlags defines every flag on flag.CommandLine, with its env var as the
+// default, and sets the flag variables to point at them.
+func defineFlags() {
 	var envNtfyDomain, ok = os.LookupEnv("NTFY_DOMAIN")
 	if ok {
 		defaultNtfyDomain = envNtfyDomain
@@ -51,64 +926,780 @@ func main() {
 	envNtfyAuth, ok := os.LookupEnv("NTFY_AUTH")
 	envSlackWebhookUrl, ok := os.LookupEnv("SLACK_WEBHOOK_URL")
 
-	ntfyDomain = flag.String("ntfy-domain", defaultNtfyDomain, "Choose the ntfy server to interact with.\nDefaults to "+UpstreamNtfyServer+" or the value of the NTFY_DOMAIN env var, if it is set")
+	ntfyDomain = flag.String("ntfy-domain", defaultNtfyDomain, "Choose the ntfy server to interact with. Separate several with commas to fail over to the next when one is unreachable\nDefaults to "+UpstreamNtfyServer+" or the value of the NTFY_DOMAIN env var, if it is set")
 	ntfyTopic = flag.String("ntfy-topic", envNtfyTopic, "Choose the ntfy topic to interact with\nDefaults to the value of the NTFY_TOPIC env var, if it is set")
-	ntfyAuth = flag.String("ntfy-auth", envNtfyAuth, "Specify token for reserved topics")
-	slackWebhookUrl = flag.String("slack-webhook", envSlackWebhookUrl, "Choose the slack webhook url to send messages to\nDefaults to the value of the SLACK_WEBHOOK_URL env var, if it is set")
-	version := flag.Bool("v", false, "prints current ntfy-to-slack version")
+	ntfyBasePath = flag.String("ntfy-base-path", os.Getenv("NTFY_BASE_PATH"), "Path the ntfy server is served under, e.g. /ntfy for https://example.com/ntfy/<topic>/json\nDefaults to the value of the NTFY_BASE_PATH env var, if it is set")
+	ntfyAuth = flag.String("ntfy-auth", envNtfyAuth, "Specify token for reserved topics\nDefaults to the value of the NTFY_AUTH env var, if it is set")
+	ntfyAuthFile = flag.String("ntfy-auth-file", os.Getenv("NTFY_AUTH_FILE"), "Read the token for reserved topics from this file, e.g. a Docker secret\nDefaults to the value of the NTFY_AUTH_FILE env var, if it is set")
+	exitOnAuthFailure = flag.Bool("exit-on-auth-failure", lookupEnvBool("EXIT_ON_AUTH_FAILURE", true), "Exit when ntfy rejects the token (401/403). When false, keep retrying, re-reading -ntfy-auth-file before each attempt\nDefaults to the value of the EXIT_ON_AUTH_FAILURE env var, if it is set")
+	ntfySince = flag.String("ntfy-since", os.Getenv("NTFY_SINCE"), "Also fetch cached messages since this duration (e.g. 10m), unix timestamp, message ID or \"all\"\nDefaults to the value of the NTFY_SINCE env var, if it is set")
+	poll = flag.Bool("poll", lookupEnvBool("POLL", false), "Fetch cached messages once, forward them and exit instead of streaming\nDefaults to the value of the POLL env var, if it is set")
+	stateFile = flag.String("state-file", os.Getenv("STATE_FILE"), "Remember the last forwarded message in this file, and resume after it on restart\nDefaults to the value of the STATE_FILE env var, if it is set")
+	maxRuntime = flag.Duration("max-runtime", lookupEnvDuration("MAX_RUNTIME", 0), "Shut down cleanly and exit 0 after running this long (e.g. 24h), for an orchestrator to restart; 0 runs forever\nDefaults to the value of the MAX_RUNTIME env var, if it is set")
+	maxConnectAttempts = flag.Int("max-connect-attempts", lookupEnvInt("MAX_CONNECT_ATTEMPTS", 0), "Exit after this many consecutive failed attempts to connect to ntfy. 0 retries forever\nDefaults to the value of the MAX_CONNECT_ATTEMPTS env var, if it is set")
+	ntfyCaCert = flag.String("ntfy-ca-cert", os.Getenv("NTFY_CA_CERT"), "Path to a PEM CA bundle to trust for the ntfy server, in addition to the system roots\nDefaults to the value of the NTFY_CA_CERT env var, if it is set")
+	ntfyClientCert = flag.String("ntfy-client-cert", os.Getenv("NTFY_CLIENT_CERT"), "Path to a PEM client certificate to present to ntfy servers requiring mutual TLS. Requires -ntfy-client-key\nDefaults to the value of the NTFY_CLIENT_CERT env var, if it is set")
+	ntfyClientKey = flag.String("ntfy-client-key", os.Getenv("NTFY_CLIENT_KEY"), "Path to the PEM private key for -ntfy-client-cert\nDefaults to the value of the NTFY_CLIENT_KEY env var, if it is set")
+	ntfyInsecureSkipVerify = flag.Bool("ntfy-insecure-skip-verify", lookupEnvBool("NTFY_INSECURE_SKIP_VERIFY", false), "Disable TLS certificate verification for the ntfy server. INSECURE, for testing only\nDefaults to the value of the NTFY_INSECURE_SKIP_VERIFY env var, if it is set")
+	slackWebhookUrl = flag.String("slack-webhook", envSlackWebhookUrl, "Choose the slack webhook url to send messages to. Separate several with commas to send every message to each of them\nDefaults to the value of the SLACK_WEBHOOK_URL env var, if it is set")
+	strictSlackUrl = flag.Bool("strict-slack-url", lookupEnvBool("STRICT_SLACK_URL", false), "Refuse to start unless every Slack webhook is a https://hooks.slack.com/services/... URL\nDefaults to the value of the STRICT_SLACK_URL env var, if it is set")
+	slackWebhookUrlFile = flag.String("slack-webhook-file", os.Getenv("SLACK_WEBHOOK_URL_FILE"), "Read the slack webhook url from this file, e.g. a Docker secret\nDefaults to the value of the SLACK_WEBHOOK_URL_FILE env var, if it is set")
+	proxyUrl = flag.String("proxy-url", os.Getenv("PROXY_URL"), "Send all outbound requests through this http://, https:// or socks5:// proxy instead of the one from HTTP_PROXY/HTTPS_PROXY/NO_PROXY\nDefaults to the value of the PROXY_URL env var, if it is set")
+	userAgent = flag.String("user-agent", lookupEnvString("USER_AGENT", defaultUserAgent()), "User-Agent sent with all outbound requests\nDefaults to the value of the USER_AGENT env var, if it is set")
+	correlationHeader = flag.Bool("correlation-header", lookupEnvBool("CORRELATION_HEADER", false), "Send the ntfy message ID as an X-Correlation-Id header on Slack and output webhook requests\nDefaults to the value of the CORRELATION_HEADER env var, if it is set")
+	dryRun = flag.Bool("dry-run", lookupEnvBool("DRY_RUN", false), "Print messages to stdout instead of sending them to Slack\nDefaults to the value of the DRY_RUN env var, if it is set")
+	slackMaxLength = flag.Int("slack-max-length", lookupEnvInt("SLACK_MAX_LENGTH", 40000), "Truncate messages longer than this many characters before sending them to Slack; 0 disables truncation\nDefaults to the value of the SLACK_MAX_LENGTH env var, if it is set")
+	asciiFallbackEnabled = flag.Bool("ascii-fallback", lookupEnvBool("ASCII_FALLBACK", false), "Transliterate non-ASCII characters in messages, e.g. é to e, and replace the rest, such as emoji, with ?, for endpoints that garble Unicode. Lossy\nDefaults to the value of the ASCII_FALLBACK env var, if it is set")
+	heartbeatInterval = flag.Duration("heartbeat-interval", lookupEnvDuration("HEARTBEAT_INTERVAL", 0), "Post -heartbeat-message to Slack this often (e.g. 6h), as a dead man's switch showing the bot is alive; 0 disables heartbeats\nDefaults to the value of the HEARTBEAT_INTERVAL env var, if it is set")
+	heartbeatMessage = flag.String("heartbeat-message", lookupEnvString("HEARTBEAT_MESSAGE", defaultHeartbeatMessage), "Template for the -heartbeat-interval message, with {{.Topic}}, {{.Time}} and {{.Vars}}, or @- to read it from stdin\nDefaults to the value of the HEARTBEAT_MESSAGE env var, if it is set")
+	maxInflight = flag.Int("max-inflight", lookupEnvInt("MAX_INFLIGHT", 0), "Maximum messages being sent at once across all destinations; further sends wait, holding up reading from ntfy. 0 means no limit\nDefaults to the value of the MAX_INFLIGHT env var, if it is set")
+	slackRateLimit = flag.Float64("slack-rate-limit", lookupEnvFloat("SLACK_RATE_LIMIT", 1), "Maximum messages per second sent to each Slack webhook; excess messages wait their turn. 0 disables rate limiting\nDefaults to the value of the SLACK_RATE_LIMIT env var, if it is set")
+	slackTimeout = flag.Duration("slack-timeout", lookupEnvDuration("SLACK_TIMEOUT", 10*time.Second), "How long to wait for Slack to accept a message\nDefaults to the value of the SLACK_TIMEOUT env var, if it is set")
+	slackMrkdwn = flag.Bool("slack-mrkdwn", lookupEnvBool("SLACK_MRKDWN", false), "Convert Markdown in messages published as Markdown (bold, italic, links) to Slack mrkdwn\nDefaults to the value of the SLACK_MRKDWN env var, if it is set")
+	noRecover = flag.Bool("no-recover", lookupEnvBool("NO_RECOVER", false), "Let a panic while handling a message crash the bot instead of logging it and skipping the message, for debugging\nDefaults to the value of the NO_RECOVER env var, if it is set")
+	slackCodeblock = flag.Bool("slack-codeblock", lookupEnvBool("SLACK_CODEBLOCK", false), "Wrap message bodies in a code block, so log lines and stack traces keep their whitespace and show monospaced. The title stays outside it\nDefaults to the value of the SLACK_CODEBLOCK env var, if it is set")
+	noMarkdown = flag.Bool("no-markdown", lookupEnvBool("NO_MARKDOWN", false), "Keep Slack markup out of the default formatting, e.g. show attachments as name: url rather than a Slack link\nDefaults to the value of the NO_MARKDOWN env var, if it is set")
+	slackFormat = flag.String("slack-format", lookupEnvString("SLACK_FORMAT", "text"), "How to lay out Slack messages: text, or attachment to show the title separately with a color bar for the priority\nDefaults to the value of the SLACK_FORMAT env var, if it is set")
+	slackPayloadTemplateText = flag.String("slack-payload-template", os.Getenv("SLACK_PAYLOAD_TEMPLATE"), "Template rendering the whole JSON payload sent to Slack webhooks, e.g. with blocks, instead of just {\"text\": ...}, or @- to read it from stdin\nDefaults to the value of the SLACK_PAYLOAD_TEMPLATE env var, if it is set")
+	tagPlacement = flag.String("tag-placement", lookupEnvString("TAG_PLACEMENT", "none"), "Where to show a message's ntfy tags: prefix, suffix or none\nDefaults to the value of the TAG_PLACEMENT env var, if it is set")
+	showTopic = flag.Bool("show-topic", lookupEnvBool("SHOW_TOPIC", true), "Prefix each message with the ntfy topic it came from, e.g. (alerts)\nDefaults to the value of the SHOW_TOPIC env var, if it is set")
+	slackPrefix = flag.String("slack-prefix", os.Getenv("SLACK_PREFIX"), "Text to put before every message, e.g. \":satellite: [prod]\", however it was formatted\nDefaults to the value of the SLACK_PREFIX env var, if it is set")
+	slackSuffix = flag.String("slack-suffix", os.Getenv("SLACK_SUFFIX"), "Text to put after every message, however it was formatted\nDefaults to the value of the SLACK_SUFFIX env var, if it is set")
+	slackTextField = flag.String("slack-text-field", lookupEnvString("SLACK_TEXT_FIELD", "text"), "JSON field the message text is sent in, for Slack-compatible webhooks expecting another, e.g. content\nDefaults to the value of the SLACK_TEXT_FIELD env var, if it is set")
+	slackLinkNames = flag.Bool("slack-link-names", lookupEnvBool("SLACK_LINK_NAMES", false), "Ask Slack to turn @here, @channel, @user and #channel in messages into real mentions and links\nDefaults to the value of the SLACK_LINK_NAMES env var, if it is set")
+	slackFooter = flag.Bool("slack-footer", lookupEnvBool("SLACK_FOOTER", false), "End every message with a footer naming this bot, its version and the ntfy topic\nDefaults to the value of the SLACK_FOOTER env var, if it is set")
+	showMetadata = flag.Bool("show-metadata", lookupEnvBool("SHOW_METADATA", false), "End each message with a line giving its topic, priority, tags and time\nDefaults to the value of the SHOW_METADATA env var, if it is set")
+	showMessageId = flag.Bool("show-message-id", lookupEnvBool("SHOW_MESSAGE_ID", false), "Append the ntfy message ID to each message sent to Slack, e.g. for deduplication downstream\nDefaults to the value of the SHOW_MESSAGE_ID env var, if it is set")
+	allowEmpty = flag.Bool("allow-empty", lookupEnvBool("ALLOW_EMPTY", false), "Forward messages even when they have no title, message or attachment\nDefaults to the value of the ALLOW_EMPTY env var, if it is set")
+	splitOnNewline = flag.Bool("split-on-newline", lookupEnvBool("SPLIT_ON_NEWLINE", false), "Send each non-empty line of a message body as its own message\nDefaults to the value of the SPLIT_ON_NEWLINE env var, if it is set")
+	splitTitle = flag.String("split-title", lookupEnvString("SPLIT_TITLE", "first"), "With -split-on-newline, put the title on the first line's message only (first) or on every one (all)\nDefaults to the value of the SPLIT_TITLE env var, if it is set")
+	batchWindow = flag.Duration("batch-window", lookupEnvDuration("BATCH_WINDOW", 0), "Collect messages for this long and send them to Slack as a single post (e.g. 5s); 0 disables batching\nDefaults to the value of the BATCH_WINDOW env var, if it is set")
+	batchMax = flag.Int("batch-max", lookupEnvInt("BATCH_MAX", 20), "Send a batch early once it holds this many messages\nDefaults to the value of the BATCH_MAX env var, if it is set")
+	digestInterval = flag.Duration("digest-interval", lookupEnvDuration("DIGEST_INTERVAL", 0), "Instead of forwarding messages as they arrive, post a digest of them every interval (e.g. 1h); 0 disables digests\nDefaults to the value of the DIGEST_INTERVAL env var, if it is set")
+	digestTemplateText = flag.String("digest-template", os.Getenv("DIGEST_TEMPLATE"), "Template rendering each -digest-interval digest from {{.Topic}}, {{.Since}}, {{.Until}} and {{.Messages}}, or @- to read it from stdin\nDefaults to the value of the DIGEST_TEMPLATE env var, if it is set")
+	collapseWindow = flag.Duration("collapse-window", lookupEnvDuration("COLLAPSE_WINDOW", 0), "Collapse messages with the same topic and title arriving within this window (e.g. 1m) into the latest one. 0 disables collapsing\nDefaults to the value of the COLLAPSE_WINDOW env var, if it is set")
+	queueSize = flag.Int("queue-size", lookupEnvInt("QUEUE_SIZE", 0), "Queue up to this many messages between reading ntfy and sending them, so a slow destination doesn't stall the subscription. 0 sends each message before reading the next\nDefaults to the value of the QUEUE_SIZE env var, if it is set")
+	queueFullPolicy = flag.String("queue-full-policy", lookupEnvString("QUEUE_FULL_POLICY", "block"), "What to do when the -queue-size queue is full: block, drop-oldest or drop-newest\nDefaults to the value of the QUEUE_FULL_POLICY env var, if it is set")
+	output = flag.String("output", lookupEnvString("OUTPUT", "slack"), "Where to deliver messages: slack, slack-workflow, mattermost, pagerduty, telegram, webhook or file\nDefaults to the value of the OUTPUT env var, if it is set")
+	pagerDutyRoutingKey = flag.String("pagerduty-routing-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "PagerDuty Events API v2 routing key, for -output=pagerduty\nDefaults to the value of the PAGERDUTY_ROUTING_KEY env var, if it is set")
+	telegramToken = flag.String("telegram-token", os.Getenv("TELEGRAM_TOKEN"), "Telegram bot token, for -output=telegram\nDefaults to the value of the TELEGRAM_TOKEN env var, if it is set")
+	telegramChatId = flag.String("telegram-chat-id", os.Getenv("TELEGRAM_CHAT_ID"), "Telegram chat to send messages to, for -output=telegram\nDefaults to the value of the TELEGRAM_CHAT_ID env var, if it is set")
+	telegramMarkdown = flag.Bool("telegram-markdown", lookupEnvBool("TELEGRAM_MARKDOWN", false), "Send messages to Telegram with parse_mode=MarkdownV2. The default formatting and the heartbeat are escaped; post-processor and template output must be valid MarkdownV2\nDefaults to the value of the TELEGRAM_MARKDOWN env var, if it is set")
+	mattermostChannel = flag.String("mattermost-channel", os.Getenv("MATTERMOST_CHANNEL"), "With -output=mattermost, post to this channel instead of the webhook's default\nDefaults to the value of the MATTERMOST_CHANNEL env var, if it is set")
+	mattermostUsername = flag.String("mattermost-username", os.Getenv("MATTERMOST_USERNAME"), "With -output=mattermost, post as this username instead of the webhook's default\nDefaults to the value of the MATTERMOST_USERNAME env var, if it is set")
+	mattermostIconUrl = flag.String("mattermost-icon-url", os.Getenv("MATTERMOST_ICON_URL"), "With -output=mattermost, post with this profile picture instead of the webhook's default\nDefaults to the value of the MATTERMOST_ICON_URL env var, if it is set")
+	outputWebhookUrl = flag.String("output-webhook-url", os.Getenv("OUTPUT_WEBHOOK_URL"), "URL to POST JSON to, for -output=webhook\nDefaults to the value of the OUTPUT_WEBHOOK_URL env var, if it is set")
+	outputTemplate = flag.String("output-template", os.Getenv("OUTPUT_TEMPLATE"), "Template rendering the JSON body POSTed by -output=webhook, e.g. {\"alert\": {{json .Title}}}, or @- to read it from stdin\nDefaults to the value of the OUTPUT_TEMPLATE env var, if it is set")
+	outputFile = flag.String("output-file", os.Getenv("OUTPUT_FILE"), "File -output=file appends messages to, one JSON object per line. Reopened on SIGHUP\nDefaults to the value of the OUTPUT_FILE env var, if it is set")
+	slackBotToken = flag.String("slack-bot-token", os.Getenv("SLACK_BOT_TOKEN"), "Post with the Slack Web API using this bot token instead of the webhook. Requires -slack-channel\nDefaults to the value of the SLACK_BOT_TOKEN env var, if it is set")
+	slackChannel = flag.String("slack-channel", os.Getenv("SLACK_CHANNEL"), "Channel ID to post to when using -slack-bot-token\nDefaults to the value of the SLACK_CHANNEL env var, if it is set")
+	slackThreadKey = flag.String("slack-thread-key", os.Getenv("SLACK_THREAD_KEY"), "Template (e.g. {{.Title}}) grouping messages into Slack threads: messages with the same key reply to the first one, or @- to read it from stdin. Requires -slack-bot-token\nDefaults to the value of the SLACK_THREAD_KEY env var, if it is set")
+	flag.Var(priorityRoutes, "priority-route", "Send messages of an ntfy priority (1-5) to their own Slack webhook, as priority=webhook_url. Takes precedence over -route. Can be repeated\nDefaults to the comma-separated value of the SLACK_PRIORITY_ROUTES env var, if it is set")
+	suppressConsecutiveDuplicates = flag.Bool("suppress-consecutive-duplicates", lookupEnvBool("SUPPRESS_CONSECUTIVE_DUPLICATES", false), "Drop a message with the same title and message as the one forwarded just before it, however long ago, e.g. from a stuck sensor\nDefaults to the value of the SUPPRESS_CONSECUTIVE_DUPLICATES env var, if it is set")
+	minPriority = flag.Int("min-priority", lookupEnvInt("MIN_PRIORITY", 1), "Drop messages with an ntfy priority below this (1-5)\nDefaults to the value of the MIN_PRIORITY env var, if it is set")
+	flag.Var(&ntfyHeaders, "ntfy-header", "Send this \"Key: Value\" header to the ntfy server, e.g. for a reverse proxy in front of it. Can be repeated\nDefaults to the comma-separated value of the NTFY_HEADERS env var, if it is set")
+	flag.Var(&topicAllow, "topic-allow", "Only forward messages from this topic, e.g. when subscribed to several. Can be repeated\nDefaults to the comma-separated value of the TOPIC_ALLOW env var, if it is set")
+	flag.Var(&topicDeny, "topic-deny", "Never forward messages from this topic. Can be repeated\nDefaults to the comma-separated value of the TOPIC_DENY env var, if it is set")
+	quietHoursWindow = flag.String("quiet-hours", os.Getenv("QUIET_HOURS"), "Hold messages below -quiet-min-priority during this daily window (e.g. 22:00-07:00), and forward them when it ends\nDefaults to the value of the QUIET_HOURS env var, if it is set")
+	quietTimezone = flag.String("quiet-timezone", lookupEnvString("QUIET_TIMEZONE", "Local"), "Time zone of -quiet-hours, e.g. Europe/London\nDefaults to the value of the QUIET_TIMEZONE env var, if it is set")
+	quietMinPriority = flag.Int("quiet-min-priority", lookupEnvInt("QUIET_MIN_PRIORITY", 4), "Lowest ntfy priority (1-5) still forwarded immediately during -quiet-hours\nDefaults to the value of the QUIET_MIN_PRIORITY env var, if it is set")
+	flag.Var(templateVars, "template-var", "Make a static key=value available to templates as {{.Vars.key}}. Can be repeated\nDefaults to the comma-separated value of the TEMPLATE_VARS env var, if it is set")
+	flag.Var(mentionMap, "mention-map", "Map a name to a Slack user ID as name=UXXXX, so {{mention \"name\"}} in templates mentions them. Can be repeated\nDefaults to the comma-separated value of the MENTION_MAP env var, if it is set")
+	flag.Var(workflowVars, "workflow-var", "Set a Slack workflow variable to a template for -output=slack-workflow, as name=template, e.g. title={{.Title}}. Can be repeated\nDefaults to the comma-separated value of the WORKFLOW_VARS env var, if it is set")
+	templateMissingKey = flag.String("template-missingkey", lookupEnvString("TEMPLATE_MISSINGKEY", "default"), "What templates do with a {{.Vars}} key no -template-var sets: default prints <no value>, zero prints nothing, error fails the template\nDefaults to the value of the TEMPLATE_MISSINGKEY env var, if it is set")
+	flag.Var(templateFor, "template-for", "Format messages for a topic with the Go template in a file, as topic=path; * sets the template for all other topics. Can be repeated\nDefaults to the comma-separated value of the TEMPLATE_FOR env var, if it is set")
+	flag.Var(&postProcessExec, "post-process-exec", "Format messages by running this command with the ntfy message JSON on stdin, and sending its stdout to Slack. Can be repeated to chain commands, each receiving the previous output as its message\nDefaults to the comma-separated value of the POST_PROCESS_EXEC env var, if it is set")
+	postProcessTimeout = flag.Duration("post-process-timeout", lookupEnvDuration("POST_PROCESS_TIMEOUT", 10*time.Second), "How long the post-process command may run before falling back to default formatting\nDefaults to the value of the POST_PROCESS_TIMEOUT env var, if it is set")
+	flag.Var(routes, "route", "Send messages from a topic to its own Slack webhook, as topic=webhook_url. Can be repeated\nDefaults to the comma-separated value of the SLACK_ROUTES env var, if it is set")
+	testMessage = flag.String("test-message", "", "Send a single test message with this body to Slack and exit, without connecting to ntfy")
+	ackActionsEnabled = flag.Bool("ack-actions", lookupEnvBool("ACK_ACTIONS", false), "After forwarding a message, invoke its ntfy http actions, e.g. to mark it as handled\nDefaults to the value of the ACK_ACTIONS env var, if it is set")
+	maxLineSize = flag.Int("max-line-size", lookupEnvInt("MAX_LINE_SIZE", 1024*1024), "Largest ntfy event, in bytes, to accept; longer events are skipped\nDefaults to the value of the MAX_LINE_SIZE env var, if it is set")
+	logKeepalives = flag.Bool("log-keepalives", lookupEnvBool("LOG_KEEPALIVES", true), "Log every keepalive from ntfy; when false, log a count every 5 minutes instead\nDefaults to the value of the LOG_KEEPALIVES env var, if it is set")
+	strictEnv = flag.Bool("strict-env", lookupEnvBool("STRICT_ENV", false), "Refuse to start, rather than warn, when an NTFY_, SLACK_ or similar env var is not one the bot reads, e.g. a typo\nDefaults to the value of the STRICT_ENV env var, if it is set")
+	debugMode = flag.Bool("debug", lookupEnvBool("DEBUG", false), "Print debug output, such as every raw line received from ntfy and every Slack response\nDefaults to the value of the DEBUG env var, if it is set")
+	printConfig = flag.String("print-config", "", "Print the effective configuration, with secrets redacted, as \"text\" or \"json\" and exit")
+	version = flag.Bool("v", false, "prints current ntfy-to-slack version")
+	versionDetail = flag.Bool("version-detailed", false, "prints ntfy-to-slack version, git commit, build date and Go version")
 
+	setFromEnvList(routes, "SLACK_ROUTES")
+	setFromEnvList(priorityRoutes, "SLACK_PRIORITY_ROUTES")
+	setFromEnvList(templateVars, "TEMPLATE_VARS")
+	setFromEnvList(workflowVars, "WORKFLOW_VARS")
+	setFromEnvList(mentionMap, "MENTION_MAP")
+	setFromEnvList(templateFor, "TEMPLATE_FOR")
+	setFromEnvList(&postProcessExec, "POST_PROCESS_EXEC")
+	setFromEnvList(&ntfyHeaders, "NTFY_HEADERS")
+	setFromEnvList(&topicAllow, "TOPIC_ALLOW")
+	setFromEnvList(&topicDeny, "TOPIC_DENY")
+}
+
+func main() {
+	defineFlags()
 	flag.Parse()
 
 	if *version {
-		println(VERSION)
+		println(versionString())
+		os.Exit(0)
+	}
+	if *versionDetail {
+		fmt.Println(versionDetailed())
 		os.Exit(0)
 	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "https://"+*ntfyDomain+"/"+*ntfyTopic+"/json", nil)
-	if ntfyAuth != nil {
-		req.Header.Add("Authorization", "Bearer "+*ntfyAuth)
+	if unknown := unknownEnvVars(); len(unknown) > 0 {
+		if *strictEnv {
+			log.Fatalf("unknown env vars %s: check them for typos, or see -help for the env vars each option reads", strings.Join(unknown, ", "))
+		}
+		fmt.Printf("warning: ignoring unknown env vars %s: check them for typos, or see -help for the env vars each option reads\n", strings.Join(unknown, ", "))
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("bot error: error on https attempt. verify network connectivity is OK. waiting 30 seconds before restarting.")
-		time.Sleep(30 * time.Second)
+	if err := resolveSecretFile(ntfyAuth, *ntfyAuthFile, "ntfy-auth", "ntfy-auth-file"); err != nil {
 		log.Fatal(err)
-	} else if resp.StatusCode != http.StatusOK {
-		sendToSlack("bot error: expected 200 OK from " + *ntfyDomain + ", instead: " + strconv.Itoa(resp.StatusCode) + ". waiting 30 seconds before restarting.")
-		fmt.Printf("bot error: expected 200 OK from %s, instead: %s. waiting 30 seconds before restarting.", *ntfyDomain, strconv.Itoa(resp.StatusCode))
-		time.Sleep(30 * time.Second)
+	}
+	if err := readTemplateFlags(map[string]*string{
+		"output-template":        outputTemplate,
+		"slack-payload-template": slackPayloadTemplateText,
+		"digest-template":        digestTemplateText,
+		"heartbeat-message":      heartbeatMessage,
+		"slack-thread-key":       slackThreadKey,
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if err := resolveSecretFile(slackWebhookUrl, *slackWebhookUrlFile, "slack-webhook", "slack-webhook-file"); err != nil {
 		log.Fatal(err)
 	}
-	defer resp.Body.Close()
 
-	var msg NtfyMessage
+	switch *printConfig {
+	case "":
+	case "text":
+		for _, setting := range configSettings() {
+			fmt.Printf("%s=%s\n", setting.Name, setting.Value)
+		}
+		os.Exit(0)
+	case "json":
+		out, err := configJSON()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(out)
+		os.Exit(0)
+	default:
+		log.Fatalf("invalid -print-config %q: expected text or json", *printConfig)
+	}
+
+	fmt.Printf("ntfy-to-slack %s starting: %s\n", versionString(), configSummary())
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		err := json.Unmarshal([]byte(scanner.Text()), &msg)
+	proxy, err := proxyFunc(*proxyUrl)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport := newTransport(proxy)
+	slackClient := &http.Client{Transport: transport, Timeout: *slackTimeout}
+
+	if *slackBotToken != "" && *slackChannel == "" {
+		log.Fatal("-slack-bot-token requires -slack-channel")
+	}
+	switch *slackFormat {
+	case "text":
+	case "attachment":
+		if *output != "slack" || *slackBotToken != "" {
+			log.Fatal("-slack-format=attachment requires -output=slack with -slack-webhook")
+		}
+	default:
+		log.Fatalf("invalid -slack-format %q: expected text or attachment", *slackFormat)
+	}
+	if *slackTextField == "" || *slackTextField == "link_names" {
+		log.Fatalf("invalid -slack-text-field %q", *slackTextField)
+	}
+	if *slackTextField != "text" && (*slackFormat != "text" || *slackPayloadTemplateText != "") {
+		log.Fatal("-slack-text-field cannot be combined with -slack-format or -slack-payload-template")
+	}
+	switch *templateMissingKey {
+	case "default", "zero", "error":
+	default:
+		log.Fatalf("invalid -template-missingkey %q: expected default, zero or error", *templateMissingKey)
+	}
+	if *slackPayloadTemplateText != "" {
+		if *slackFormat != "text" {
+			log.Fatal("-slack-payload-template cannot be combined with -slack-format")
+		}
+		slackPayloadTemplate, err = parseTemplate("slack-payload-template", *slackPayloadTemplateText)
 		if err != nil {
-			println(err)
-			fmt.Printf("while processing %s", scanner.Text())
-			sendToSlack("bot error: " + err.Error())
+			log.Fatalf("invalid -slack-payload-template: %s", err)
 		}
+		example := NtfyMessage{Id: "example", Time: 1, Event: "message", Topic: "example", Title: "example", Message: "example"}
+		if _, err := renderJSONTemplate(slackPayloadTemplate, SlackMessage{Topic: "example", Text: "example", Source: &example}); err != nil {
+			log.Fatalf("invalid -slack-payload-template: %s", err)
+		}
+	}
+	switch *splitTitle {
+	case "first", "all":
+	default:
+		log.Fatalf("invalid -split-title %q: expected first or all", *splitTitle)
+	}
+	switch *tagPlacement {
+	case "prefix", "suffix", "none":
+	default:
+		log.Fatalf("invalid -tag-placement %q: expected prefix, suffix or none", *tagPlacement)
+	}
+	if *maxLineSize <= 0 {
+		log.Fatal("-max-line-size must be positive")
+	}
+	if *noMarkdown && *slackCodeblock {
+		log.Fatal("-no-markdown cannot be combined with -slack-codeblock")
+	}
+	if *noMarkdown && *slackMrkdwn {
+		log.Fatal("-no-markdown cannot be combined with -slack-mrkdwn")
+	}
+	if *output == "mattermost" && *slackMrkdwn {
+		log.Fatal("-slack-mrkdwn cannot be combined with -output=mattermost, which renders markdown as-is")
+	}
+	if *strictSlackUrl {
+		if *output == "slack" && *slackBotToken == "" && !*dryRun {
+			if len(webhookUrls()) == 0 {
+				log.Fatalf("invalid -slack-webhook: %s", validateSlackWebhookUrl(""))
+			}
+			for _, u := range webhookUrls() {
+				if err := validateSlackWebhookUrl(u); err != nil {
+					log.Fatalf("invalid -slack-webhook: %s", err)
+				}
+			}
+		}
+		for topic, webhook := range routes {
+			if err := validateSlackWebhookUrl(webhook); err != nil {
+				log.Fatalf("invalid -route for topic %q: %s", topic, err)
+			}
+		}
+		for p, webhook := range priorityRoutes {
+			if err := validateSlackWebhookUrl(webhook); err != nil {
+				log.Fatalf("invalid -priority-route for priority %s: %s", p, err)
+			}
+		}
+	}
+	if *digestInterval > 0 {
+		if *batchWindow > 0 {
+			log.Fatal("-digest-interval cannot be combined with -batch-window")
+		}
+		if *ackActionsEnabled {
+			log.Fatal("-digest-interval cannot be combined with -ack-actions")
+		}
+		if *digestTemplateText != "" {
+			digestTemplate, err = parseDigestTemplate(*digestTemplateText)
+			if err != nil {
+				log.Fatalf("invalid -digest-template: %s", err)
+			}
+		}
+	} else if *digestTemplateText != "" {
+		log.Fatal("-digest-template requires -digest-interval")
+	}
+	if *ackActionsEnabled {
+		if *batchWindow > 0 {
+			log.Fatal("-ack-actions cannot be combined with -batch-window")
+		}
+		actionClient = &http.Client{Transport: transport, Timeout: *slackTimeout}
+	}
+	if *slackThreadKey != "" {
+		if *slackBotToken == "" {
+			log.Fatal("-slack-thread-key requires -slack-bot-token, incoming webhooks cannot reply in threads")
+		}
+		if *batchWindow > 0 {
+			log.Fatal("-slack-thread-key cannot be combined with -batch-window")
+		}
+		threadKeyTemplate, err = parseTemplate("slack-thread-key", *slackThreadKey)
+		if err != nil {
+			log.Fatalf("invalid -slack-thread-key: %s", err)
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("dry-run mode active: messages will be printed to stdout and NOT sent to Slack\n")
+		sender = &DryRunSender{Payload: webhookPayload()}
+	} else {
+		sender, err = newSender(slackClient)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if fileSender, ok := sender.(*FileSender); ok {
+			reopenOnHangup(fileSender)
+		}
+		if len(routes) > 0 || len(priorityRoutes) > 0 {
+			routed := make(map[string]MessageSender, len(routes))
+			for topic, webhook := range routes {
+				if routed[topic], err = newRouteSender(webhook, slackClient); err != nil {
+					log.Fatalf("invalid -route for topic %q: %s", topic, err)
+				}
+			}
+			priorityRouted := make(map[int]MessageSender, len(priorityRoutes))
+			for p, webhook := range priorityRoutes {
+				priority, err := strconv.Atoi(p)
+				if err != nil || priority < 1 || priority > 5 {
+					log.Fatalf("invalid -priority-route %q: priority must be 1-5", p)
+				}
+				if priorityRouted[priority], err = newRouteSender(webhook, slackClient); err != nil {
+					log.Fatalf("invalid -priority-route for priority %s: %s", p, err)
+				}
+			}
+			sender = &RoutingSender{Routes: routed, PriorityRoutes: priorityRouted, Default: sender}
+		}
+	}
+	if *asciiFallbackEnabled {
+		sender = &ASCIISender{Sender: sender}
+	}
+	if *maxInflight < 0 {
+		log.Fatal("-max-inflight must not be negative")
+	} else if *maxInflight > 0 {
+		sender = NewInflightLimitedSender(sender, *maxInflight)
+	}
+
+	if len(templateFor) > 0 {
+		if len(postProcessExec) > 0 {
+			log.Fatal("-template-for cannot be combined with -post-process-exec")
+		}
+		postProcessor, err = NewTemplatePostProcessor(templateFor)
+		if err != nil {
+			log.Fatalf("invalid -template-for: %s", err)
+		}
+	}
+	if len(postProcessExec) == 1 {
+		postProcessor = &ExecPostProcessor{Command: postProcessExec[0], Timeout: *postProcessTimeout}
+	} else if len(postProcessExec) > 1 {
+		pipeline := PipelinePostProcessor{}
+		for _, command := range postProcessExec {
+			pipeline = append(pipeline, &ExecPostProcessor{Command: command, Timeout: *postProcessTimeout})
+		}
+		postProcessor = pipeline
+	}
 
-		timeT := time.Unix(msg.Time, 0).String()
+	if *testMessage != "" {
+		msg := NtfyMessage{
+			Id:      "test",
+			Time:    time.Now().Unix(),
+			Event:   "message",
+			Topic:   *ntfyTopic,
+			Title:   "ntfy-to-slack test",
+			Message: *testMessage,
+		}
+		slackMsg := newSlackMessage(msg.Topic, renderMessage(msg))
+		slackMsg.ThreadKey = threadKey(msg)
+		slackMsg.Source = &msg
+		if err := sender.Send(slackMsg); err != nil {
+			fmt.Printf("test message failed: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("test message sent: %s\n", slackMsg.Text)
+		os.Exit(0)
+	}
 
-		switch msg.Event {
-		case "open":
-			fmt.Printf("%s: %s subscription established\n", timeT, *ntfyDomain)
-			sendToSlack("bot restarted; " + *ntfyDomain + " subscription established")
-		case "keepalive":
-			fmt.Printf("%s: keepalive\n", timeT)
-		case "message":
-			{
-				fmt.Printf("%s: sending to Slack: %s / %s\n", timeT, msg.Title, msg.Message)
-				sendToSlack(msg.Title + ": " + msg.Message)
+	if *batchWindow > 0 {
+		batcher = NewBatcher(realClock{}, *batchWindow, *batchMax, func(topic string, texts []string, msgs []NtfyMessage) {
+			debugf("flushing batch of %d messages for topic %s", len(texts), topic)
+			if err := sendToSlack(topic, strings.Join(texts, "\n")); err != nil {
+				return
+			}
+			for _, msg := range msgs {
+				markHandled(msg)
 			}
+		})
+	}
+
+	if *digestInterval > 0 {
+		digester = NewDigester(realClock{}, *digestInterval, func(topic string, data DigestData) {
+			debugf("sending digest of %d messages for topic %s", len(data.Messages), topic)
+			text, err := digestText(digestTemplate, data)
+			if err != nil {
+				fmt.Printf("bot error: digest-template failed for topic %s: %s. sending the default digest\n", topic, err)
+				text, _ = digestText(nil, data)
+				text = literalText(text)
+			} else if digestTemplate == nil {
+				text = literalText(text)
+			}
+			if err := sendToSlack(topic, text); err != nil {
+				return
+			}
+			for _, msg := range data.Messages {
+				markHandled(msg)
+			}
+		})
+	}
+
+	if *queueSize > 0 {
+		switch *queueFullPolicy {
+		case "block", "drop-oldest", "drop-newest":
 		default:
-			fmt.Printf("bad message received: %s\n", scanner.Text())
+			log.Fatalf("invalid -queue-full-policy %q: expected block, drop-oldest or drop-newest", *queueFullPolicy)
+		}
+		messageQueue = NewMessageQueue(*queueSize, *queueFullPolicy, forwardQueued)
+	}
+
+	if *collapseWindow > 0 {
+		collapser = NewCollapser(realClock{}, *collapseWindow, deliverMessage)
+	}
+
+	if *quietHoursWindow != "" {
+		location, err := time.LoadLocation(*quietTimezone)
+		if err != nil {
+			log.Fatalf("invalid -quiet-timezone: %s", err)
 		}
+		quietHours, err = NewQuietHours(realClock{}, *quietHoursWindow, location, *quietMinPriority, func(msgs []NtfyMessage) {
+			fmt.Printf("quiet hours over, forwarding %d held messages\n", len(msgs))
+			for _, msg := range msgs {
+				deliverMessage(msg)
+			}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Printf("received %s, shutting down\n", sig)
+		shutdown(0)
+	}()
+
+	if *heartbeatInterval > 0 {
+		tmpl, err := parseTemplate("heartbeat-message", *heartbeatMessage)
+		if err != nil {
+			log.Fatalf("invalid -heartbeat-message: %s", err)
+		}
+		startHeartbeat(*heartbeatInterval, tmpl)
+	}
+
+	if *maxRuntime > 0 {
+		time.AfterFunc(*maxRuntime, func() {
+			fmt.Printf("max runtime of %s reached, shutting down\n", *maxRuntime)
+			shutdown(0)
+		})
+	}
+
+	if *ntfyInsecureSkipVerify {
+		fmt.Printf("WARNING: TLS certificate verification is DISABLED for %s. the ntfy connection is open to interception; do not use this in production.\n", *ntfyDomain)
+	}
+	client, err := newNtfyClient(transport, *ntfyCaCert, *ntfyClientCert, *ntfyClientKey, *ntfyInsecureSkipVerify)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := validateNtfyBasePath(*ntfyBasePath); err != nil {
+		log.Fatal(err)
+	}
+	ntfyRequestHeaders, err = parseHeaders(ntfyHeaders)
+	if err != nil {
+		log.Fatalf("invalid -ntfy-header: %s", err)
+	}
+	if ntfyRequestHeaders.Get("Authorization") != "" && *ntfyAuth != "" {
+		log.Fatal("-ntfy-header cannot set Authorization together with -ntfy-auth")
+	}
+	// -ntfy-domain may list failover servers; *ntfyDomain is the one in use
+	ntfyDomains := splitList(*ntfyDomain)
+	if len(ntfyDomains) == 0 {
+		log.Fatal("-ntfy-domain must not be empty")
+	}
+	for _, domain := range ntfyDomains {
+		if _, err := url.Parse(ntfyUrl(domain, *ntfyTopic)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	query := url.Values{}
+	if *ntfySince != "" {
+		query.Set("since", *ntfySince)
+	}
+	if *poll {
+		query.Set("poll", "1")
+	}
+
+	if *stateFile != "" {
+		cursorStore = loadCursorStore(*stateFile)
+		// a dry run must not skip messages for the next real run
+		cursorStore.ReadOnly = *dryRun
+		if cursorStore.Since() != "" {
+			query.Set("since", cursorStore.Since())
+		}
+	}
+
+	shutdown(subscribe(client, realClock{}, ntfyDomains, query))
+}
+
+// subscribe forwards the ntfy stream of *ntfyTopic from the first of domains,
+// reconnecting whenever the connection fails or ends and failing over to the
+// next domain after repeated failures. It returns the exit code once it gives
+// up, or once a -poll completes.
+func subscribe(client *http.Client, clock Clock, domains []string, query url.Values) int {
+	domainIndex := 0
+	*ntfyDomain = domains[domainIndex]
+
+	// a message ID is only known to the server that sent it, and another
+	// server sends its whole cache for one it does not know, so failing over
+	// resumes from the time of the last message instead
+	resumeTime := clock.Now()
+	if cursorStore != nil && cursorStore.Since() != "" {
+		resumeTime = time.Unix(cursorStore.SinceTime(), 0)
+	}
+
+	failures := 0
+	domainFailures := 0
+	// how many servers in a row rejected the subscription outright
+	fatalFailures := 0
+	for {
+		if failures > 0 && *ntfyAuthFile != "" && !*exitOnAuthFailure {
+			// pick up a token refreshed on disk since the last attempt
+			if auth, err := readSecretFile(*ntfyAuthFile); err != nil {
+				fmt.Printf("bot error: re-reading -ntfy-auth-file: %s\n", err)
+			} else {
+				*ntfyAuth = auth
+			}
+		}
+		resp, err := connectNtfy(client, *ntfyDomain, *ntfyTopic, *ntfyAuth, query)
+		if err != nil && *poll {
+			fmt.Printf("bot error: %s. exiting.\n", err)
+			return 1
+		}
+		if err != nil {
+			fatal := isFatalConnectError(err) && (*exitOnAuthFailure || !isAuthError(err))
+			if !fatal {
+				fatalFailures = 0
+			} else if fatalFailures++; fatalFailures >= len(domains) {
+				sendToSlack(*ntfyTopic, literalText("bot error: "+err.Error()+". not retrying, exiting."))
+				fmt.Printf("bot error: %s. not retrying, exiting.\n", err)
+				return 1
+			}
+
+			failures++
+			if *maxConnectAttempts > 0 && failures >= *maxConnectAttempts {
+				fmt.Printf("bot error: %s. giving up after %d failed connection attempts, exiting.\n", err, failures)
+				return 1
+			}
+			domainFailures++
+			if len(domains) > 1 && (fatal || domainFailures >= failoverAttempts) {
+				domainIndex = (domainIndex + 1) % len(domains)
+				fmt.Printf("bot error: %s. failing over from %s to %s.\n", err, *ntfyDomain, domains[domainIndex])
+				*ntfyDomain = domains[domainIndex]
+				domainFailures = 0
+				if isNtfyMessageId(query.Get("since")) {
+					query.Set("since", strconv.FormatInt(resumeTime.Unix(), 10))
+				}
+				continue
+			}
+			delay := reconnectDelay
+			var connectErr *NtfyConnectError
+			if errors.As(err, &connectErr) {
+				// retrying sooner than a rate-limiting server asks only prolongs the limit
+				if connectErr.RetryAfter > delay {
+					delay = connectErr.RetryAfter.Round(time.Second)
+				}
+				if failures == 1 {
+					sendToSlack(*ntfyTopic, literalText(fmt.Sprintf("bot error: %s. retrying every %s.", err, delay)))
+				}
+				fmt.Printf("bot error: %s. waiting %s before retrying.\n", err, delay)
+			} else {
+				fmt.Printf("bot error: error on https attempt (%s). verify network connectivity is OK. waiting %s before retrying.\n", err, delay)
+			}
+			clock.Sleep(delay)
+			continue
+		}
+
+		failures = 0
+		domainFailures = 0
+		fatalFailures = 0
+		connectedAt := clock.Now()
+		err = processStream(resp.Body)
+		resp.Body.Close()
+
+		// reconnect after the last message read, rather than repeating
+		// -ntfy-since and forwarding the cached messages all over again
+		if lastMessage.Id != "" {
+			query.Set("since", lastMessage.Id)
+			resumeTime = time.Unix(lastMessage.Time, 0)
+		} else {
+			query.Set("since", strconv.FormatInt(connectedAt.Unix(), 10))
+			resumeTime = connectedAt
+		}
+
+		if *poll {
+			fmt.Printf("poll of %s complete, exiting.\n", *ntfyDomain)
+			return 0
+		}
+
+		if err != nil {
+			fmt.Printf("bot error: connection to %s lost (%s). waiting %s before reconnecting.\n", *ntfyDomain, err, reconnectDelay)
+		} else {
+			debugf("connection to %s closed by the server. reconnecting in %s.", *ntfyDomain, reconnectDelay)
+		}
+		clock.Sleep(reconnectDelay)
 	}
 }
+
+// processStream forwards every message read from an ntfy JSON stream until it
+// ends. It returns nil if the server closed the stream, or the read error that
+// ended it.
+func processStream(body io.Reader) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, tooLong, err := readLine(reader, *maxLineSize)
+		if err != nil {
+			if len(line) > 0 || tooLong > 0 {
+				// ntfy ends every event with a newline, so the stream broke
+				// mid-event. the fragment was never read as a message, and
+				// reconnecting resumes after lastMessage, so it is fetched again.
+				debugf("discarding %d bytes of a partial line at the end of the stream", len(line)+tooLong)
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if tooLong > 0 {
+			fmt.Printf("skipping %d byte line from ntfy, longer than -max-line-size %d\n", tooLong, *maxLineSize)
+			sendToSlack(*ntfyTopic, literalText(fmt.Sprintf("bot error: skipped a %d byte message, longer than -max-line-size", tooLong)))
+		} else if len(line) > 0 {
+			processLine(line)
+		}
+	}
+}
+
+// lastMessage is the last message read from the ntfy stream, which reconnects
+// resume after.
+var lastMessage Cursor
+
+// processLine handles a single JSON event from the ntfy stream.
+func processLine(line []byte) {
+	defer recoverMessage(string(line))
+	debugf("received from ntfy: %s", line)
+	var msg NtfyMessage
+	err := json.Unmarshal(line, &msg)
+	if err != nil {
+		println(err)
+		fmt.Printf("while processing %s", line)
+		sendToSlack(*ntfyTopic, literalText("bot error: "+err.Error()))
+	}
+
+	// so templates and outputs see {{.Priority}} as 3 rather than 0
+	if msg.Event == "message" {
+		msg.Priority = msg.priority()
+		if msg.Id != "" {
+			lastMessage = Cursor{Id: msg.Id, Time: msg.Time}
+		}
+	}
+
+	handler, ok := eventHandlers[msg.Event]
+	if !ok {
+		fmt.Printf("bad message received: %s\n", line)
+		return
+	}
+	handler(msg, time.Unix(msg.Time, 0).String())
+}
+
+// eventHandlers maps each ntfy event type to how it is handled. Events not
+// listed here are unexpected and logged as bad messages.
+var eventHandlers = map[string]func(msg NtfyMessage, timeT string){
+	"open":           handleOpen,
+	"keepalive":      handleKeepalive,
+	"message":        handleMessage,
+	"poll_request":   ignoreEvent,
+	"message_delete": ignoreEvent,
+	"message_clear":  ignoreEvent,
+}
+
+func handleOpen(msg NtfyMessage, timeT string) {
+	fmt.Printf("%s: %s subscription established\n", timeT, *ntfyDomain)
+	sendToSlack(*ntfyTopic, literalText("bot restarted; "+*ntfyDomain+" subscription established"))
+}
+
+// keepaliveSummaryInterval is how often keepalives are summarised when
+// -log-keepalives is off.
+const keepaliveSummaryInterval = 5 * time.Minute
+
+var keepaliveCount int
+var keepaliveSince = time.Now()
+
+func handleKeepalive(msg NtfyMessage, timeT string) {
+	if *logKeepalives {
+		fmt.Printf("%s: keepalive\n", timeT)
+		return
+	}
+
+	keepaliveCount++
+	if elapsed := time.Since(keepaliveSince); elapsed >= keepaliveSummaryInterval {
+		fmt.Printf("%s: %d keepalives in the last %s\n", timeT, keepaliveCount, elapsed.Round(time.Second))
+		keepaliveCount = 0
+		keepaliveSince = time.Now()
+	}
+}
+
+func handleMessage(msg NtfyMessage, timeT string) {
+	fmt.Printf("%s: sending message %s to Slack: %s / %s\n", timeT, msg.Id, msg.Title, msg.Message)
+	parts := splitMessage(msg)
+	if cursorStore != nil {
+		for _, part := range parts {
+			cursorStore.Track(part)
+		}
+	}
+	for _, part := range parts {
+		if messageQueue != nil {
+			messageQueue.Enqueue(part)
+		} else {
+			forwardMessage(part)
+		}
+	}
+}
+
+// forwardQueued forwards a message taken off the -queue-size queue.
+func forwardQueued(msg NtfyMessage) {
+	defer recoverMessage(msg)
+	forwardMessage(msg)
+}
+
+// recoverMessage, deferred around handling a message, logs a panic along with
+// the offending message and lets the bot carry on with the next one, unless
+// -no-recover is set.
+func recoverMessage(msg any) {
+	if *noRecover {
+		return
+	}
+	if r := recover(); r != nil {
+		fmt.Printf("bot error: recovered from panic while handling %+v: %v\n%s", msg, r, debug.Stack())
+	}
+}
+
+// splitMessage returns msg as one message per non-empty line of its body with
+// -split-on-newline, or as is otherwise. The title is kept on the first part,
+// or every part with -split-title=all, while attachments and actions stay on
+// the first part only.
+func splitMessage(msg NtfyMessage) []NtfyMessage {
+	if !*splitOnNewline || !strings.Contains(msg.Message, "\n") {
+		return []NtfyMessage{msg}
+	}
+
+	var parts []NtfyMessage
+	for _, line := range strings.Split(msg.Message, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		part := msg
+		part.Message = line
+		if len(parts) > 0 {
+			part.Attachment = nil
+			part.Actions = nil
+			if *splitTitle != "all" {
+				part.Title = ""
+			}
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return []NtfyMessage{msg}
+	}
+	return parts
+}
+
+// ignoreEvent handles events ntfy sends that have nothing to forward.
+func ignoreEvent(msg NtfyMessage, timeT string) {
+	debugf("%s: ignoring %s event", timeT, msg.Event)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// the flags are only defined by main, so give every flag variable its
+	// default before any test reads it
+	defineFlags()
+	os.Exit(m.Run())
+}
+
+// setFlag sets the flag variable p to v for the rest of the test.
+func setFlag[T any](t *testing.T, p *T, v T) {
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// recordingSender records the messages it is asked to send, and fails every
+// send with err if it is set.
+type recordingSender struct {
+	sent []SlackMessage
+	err  error
+}
+
+func (s *recordingSender) Send(msg SlackMessage) error {
+	s.sent = append(s.sent, msg)
+	return s.err
+}
+
+// useSender makes s the sender for the rest of the test.
+func useSender(t *testing.T, s MessageSender) {
+	old := sender
+	sender = s
+	t.Cleanup(func() { sender = old })
+}
+
+type panickingPostProcessor struct{}
+
+func (panickingPostProcessor) Process(msg NtfyMessage) (string, error) {
+	panic("post-processor bug")
+}
+
+func TestProcessLineRecoversFromPanic(t *testing.T) {
+	s := &recordingSender{}
+	useSender(t, s)
+	postProcessor = panickingPostProcessor{}
+	defer func() { postProcessor = nil }()
+
+	processLine([]byte(`{"id":"a","time":1,"event":"message","topic":"alerts","message":"first"}`))
+	if len(s.sent) != 0 {
+		t.Fatalf("sent %d messages for a message whose formatting panicked, want 0", len(s.sent))
+	}
+
+	postProcessor = nil
+	processLine([]byte(`{"id":"b","time":2,"event":"message","topic":"alerts","message":"second"}`))
+	if len(s.sent) != 1 || s.sent[0].Source.Id != "b" {
+		t.Fatalf("after a panic, sent %+v, want the next message", s.sent)
+	}
+}
+
+func TestFailedSendDoesNotAdvanceCursor(t *testing.T) {
+	useSender(t, &recordingSender{err: errors.New("slack is down")})
+	cursorStore = &CursorStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	defer func() { cursorStore = nil }()
+
+	deliverMessage(NtfyMessage{Id: "a", Time: 1, Event: "message", Topic: "alerts", Message: "lost"})
+	if since := cursorStore.Since(); since != "" {
+		t.Errorf("cursor advanced to %q after a failed send, want it unchanged", since)
+	}
+}
+
+func TestFailedSendHoldsCursorBeforeLaterMessages(t *testing.T) {
+	failed := false
+	useSender(t, senderFunc(func(msg SlackMessage) error {
+		if msg.Source.Id == "a" {
+			failed = true
+			return errors.New("slack is down")
+		}
+		return nil
+	}))
+	cursorStore = &CursorStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	defer func() { cursorStore = nil }()
+
+	processLine([]byte(`{"id":"first","time":1,"event":"message","topic":"alerts","message":"sent"}`))
+	processLine([]byte(`{"id":"a","time":2,"event":"message","topic":"alerts","message":"lost"}`))
+	processLine([]byte(`{"id":"b","time":3,"event":"message","topic":"alerts","message":"sent"}`))
+	if !failed {
+		t.Fatal("message a was never sent")
+	}
+	if since := cursorStore.Since(); since != "first" {
+		t.Errorf("cursor = %q after a failed send followed by a successful one, want %q", since, "first")
+	}
+}
+
+func TestCursorStoreSave(t *testing.T) {
+	s := &CursorStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	msgs := []NtfyMessage{{Id: "a", Time: 1}, {Id: "b", Time: 2}, {Id: "b", Time: 2}, {Id: "c", Time: 3}}
+	for _, msg := range msgs {
+		s.Track(msg)
+	}
+
+	s.Save(msgs[1])
+	s.Save(msgs[3])
+	if since := s.Since(); since != "" {
+		t.Errorf("cursor = %q while a is pending, want it unchanged", since)
+	}
+	s.Save(msgs[0])
+	if since := s.Since(); since != "a" {
+		t.Errorf("cursor = %q while one part of b is pending, want %q", since, "a")
+	}
+	s.Save(msgs[2])
+	if since := s.Since(); since != "c" {
+		t.Errorf("cursor = %q once every message is saved, want %q", since, "c")
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("%d messages still pending, want 0", len(s.pending))
+	}
+
+	b, err := os.ReadFile(s.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loadCursorStore(s.Path).Since(); got != "c" {
+		t.Errorf("state file %s resumes from %q, want %q", b, got, "c")
+	}
+}
+
+func TestFormatMessageShowMessageId(t *testing.T) {
+	setFlag(t, showMessageId, true)
+
+	got := formatMessage(NtfyMessage{Id: "abc123", Title: "disk", Message: "full"})
+	if want := "disk: full (id: abc123)"; got != want {
+		t.Errorf("formatMessage() = %q, want %q", got, want)
+	}
+}
+
+func TestNtfyMessageMarshalsLowercaseKeys(t *testing.T) {
+	msg := NtfyMessage{Id: "abc123", Time: 1, Event: "message", Topic: "alerts", Title: "disk", Message: "full"}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys map[string]any
+	if err := json.Unmarshal(b, &keys); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"id", "time", "event", "topic", "title", "message"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("%s has no %q key", b, key)
+		}
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	attachment := &Attachment{Name: "log.txt", URL: "https://ntfy.sh/file/log.txt"}
+	tests := []struct {
+		name       string
+		msg        NtfyMessage
+		codeblock  bool
+		noMarkdown bool
+		tags       string
+		want       string
+	}{
+		{name: "title and message", msg: NtfyMessage{Title: "disk", Message: "full"}, want: "disk: full"},
+		{name: "message only", msg: NtfyMessage{Message: "full"}, want: "full"},
+		{name: "title only", msg: NtfyMessage{Title: "disk"}, want: "disk"},
+		{name: "no title or message", msg: NtfyMessage{}, want: ""},
+		{name: "codeblock", msg: NtfyMessage{Title: "disk", Message: "full"}, codeblock: true, want: "disk\n```\nfull\n```"},
+		{name: "tags prefix", msg: NtfyMessage{Message: "full", Tags: []string{"warning", "disk"}}, tags: "prefix", want: "[warning, disk] full"},
+		{name: "tags prefix with title", msg: NtfyMessage{Title: "disk", Message: "full", Tags: []string{"warning"}}, tags: "prefix", want: "[warning] disk: full"},
+		{name: "tags suffix", msg: NtfyMessage{Message: "full", Tags: []string{"warning"}}, tags: "suffix", want: "full [warning]"},
+		{name: "tags suffix with title", msg: NtfyMessage{Title: "disk", Message: "full", Tags: []string{"warning"}}, tags: "suffix", want: "disk: full [warning]"},
+		{name: "tags none", msg: NtfyMessage{Message: "full", Tags: []string{"warning"}}, tags: "none", want: "full"},
+		{name: "tags none with title", msg: NtfyMessage{Title: "disk", Message: "full", Tags: []string{"warning"}}, tags: "none", want: "disk: full"},
+		{name: "no tags prefix", msg: NtfyMessage{Title: "disk", Message: "full"}, tags: "prefix", want: "disk: full"},
+		{name: "attachment", msg: NtfyMessage{Message: "log", Attachment: attachment}, want: "log\n<https://ntfy.sh/file/log.txt|log.txt>"},
+		{name: "no-markdown title and message", msg: NtfyMessage{Title: "disk", Message: "full"}, noMarkdown: true, want: "disk: full"},
+		{name: "no-markdown attachment", msg: NtfyMessage{Message: "log", Attachment: attachment}, noMarkdown: true, want: "log\nlog.txt: https://ntfy.sh/file/log.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, slackCodeblock, tt.codeblock)
+			setFlag(t, noMarkdown, tt.noMarkdown)
+			if tt.tags != "" {
+				setFlag(t, tagPlacement, tt.tags)
+			}
+			if got := formatMessage(tt.msg); got != tt.want {
+				t.Errorf("formatMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessLineIgnoresPollRequest(t *testing.T) {
+	if _, ok := eventHandlers["poll_request"]; !ok {
+		t.Fatal("poll_request has no event handler, so it is logged as a bad message")
+	}
+
+	s := &recordingSender{}
+	useSender(t, s)
+	processLine([]byte(`{"id":"a","time":1,"event":"poll_request","topic":"alerts","message":"new message"}`))
+	if len(s.sent) != 0 {
+		t.Errorf("sent %+v for a poll_request, want nothing", s.sent)
+	}
+}
+
+func TestProcessLineDefaultsMissingPriority(t *testing.T) {
+	s := &recordingSender{}
+	useSender(t, s)
+	processLine([]byte(`{"id":"a","time":1,"event":"message","topic":"alerts","message":"no priority"}`))
+	if len(s.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(s.sent))
+	}
+	if got := s.sent[0].Source.Priority; got != defaultPriority {
+		t.Errorf("priority of a message without one = %d, want %d", got, defaultPriority)
+	}
+}
+
+func TestNewRouteSenderUsesOutput(t *testing.T) {
+	setFlag(t, output, "webhook")
+	s, err := newRouteSender("https://example.com/hook", http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.(*JSONWebhookSender); !ok {
+		t.Errorf("route sender for -output=webhook is a %T, want a *JSONWebhookSender", s)
+	}
+
+	setFlag(t, output, "telegram")
+	if _, err := newRouteSender("https://example.com/hook", http.DefaultClient); err == nil {
+		t.Error("route sender for -output=telegram was built, want an error")
+	}
+}
+
+func TestLiteralTextEscapesForTelegramMarkdown(t *testing.T) {
+	setFlag(t, output, "telegram")
+	setFlag(t, telegramMarkdown, true)
+
+	got := literalText("heartbeat: ntfy-to-slack is running, subscribed to alerts.prod")
+	if want := `heartbeat: ntfy\-to\-slack is running, subscribed to alerts\.prod`; got != want {
+		t.Errorf("literalText() = %q, want %q", got, want)
+	}
+}
+
+func TestDeliverMessagePrefixSuffix(t *testing.T) {
+	setFlag(t, slackPrefix, ":satellite: [prod]")
+	setFlag(t, slackSuffix, "(eu)")
+	setFlag(t, showTopic, false)
+	s := &recordingSender{}
+	useSender(t, s)
+
+	deliverMessage(NtfyMessage{Id: "a", Time: 1, Event: "message", Topic: "alerts", Title: "disk", Message: "full"})
+	if len(s.sent) != 1 || s.sent[0].Text != ":satellite: [prod] disk: full (eu)" {
+		t.Errorf("sent %+v, want one message with the prefix and suffix", s.sent)
+	}
+}
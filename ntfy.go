@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NtfyConnectError reports an unexpected HTTP status when subscribing to ntfy.
+// RetryAfter is how long the server asked us to wait, if it did. Code and
+// Message are from ntfy's JSON error body, if it sent one, and Token is
+// whether we sent a token.
+type NtfyConnectError struct {
+	Domain     string
+	Topic      string
+	StatusCode int
+	RetryAfter time.Duration
+	Code       int
+	Message    string
+	Token      bool
+}
+
+// ntfyErrorForbidden is ntfy's error code for a topic the client may not access.
+const ntfyErrorForbidden = 40301
+
+// ntfyErrorBody is the JSON ntfy describes errors with.
+type ntfyErrorBody struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+func (e *NtfyConnectError) Error() string {
+	if e.StatusCode == http.StatusTooManyRequests {
+		return fmt.Sprintf("rate limited by ntfy server %s", e.Domain)
+	}
+	if e.StatusCode == http.StatusForbidden && e.Code == ntfyErrorForbidden {
+		if e.Token {
+			return fmt.Sprintf("token lacks read (subscribe) permission for topic %s on %s", e.Topic, e.Domain)
+		}
+		return fmt.Sprintf("topic %s on %s is reserved; set -ntfy-auth to a token with read (subscribe) permission", e.Topic, e.Domain)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("expected 200 OK from %s, instead: %d (%s)", e.Domain, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("expected 200 OK from %s, instead: %d", e.Domain, e.StatusCode)
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an
+// HTTP date, returning 0 if it is missing or invalid.
+func retryAfter(header string) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// newNtfyClient returns the HTTP client used for the ntfy subscription, built
+// on its own copy of transport so TLS settings don't leak into other clients.
+// It trusts the PEM bundle at caCertPath in addition to the system roots if it is set,
+// and presents the keypair at clientCertPath and clientKeyPath to servers requiring mutual TLS.
+func newNtfyClient(transport *http.Transport, caCertPath string, clientCertPath string, clientKeyPath string, insecureSkipVerify bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if (clientCertPath == "") != (clientKeyPath == "") {
+		return nil, errors.New("-ntfy-client-cert and -ntfy-client-key must be set together")
+	}
+	if clientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading ntfy client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if caCertPath != "" {
+		pem, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading ntfy CA certificate: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caCertPath)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	ntfyTransport := transport.Clone()
+	ntfyTransport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: ntfyTransport}, nil
+}
+
+// ntfyUrl returns the JSON stream URL for topic on domain.
+func ntfyUrl(domain string, topic string) string {
+	return "https://" + domain + ntfyBasePathPrefix(*ntfyBasePath) + "/" + topic + "/json"
+}
+
+// ntfyBasePathPrefix normalises the -ntfy-base-path, such as "ntfy/" or
+// "/ntfy", to "/ntfy", or "" if there is none.
+func ntfyBasePathPrefix(basePath string) string {
+	basePath = strings.Trim(basePath, "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// validateNtfyBasePath checks that basePath is only a path, so it cannot
+// change the host, query or fragment of the subscription URL.
+func validateNtfyBasePath(basePath string) error {
+	parsed, err := url.Parse(ntfyBasePathPrefix(basePath))
+	if err != nil {
+		return fmt.Errorf("invalid -ntfy-base-path %q: %w", basePath, err)
+	}
+	if parsed.Scheme != "" || parsed.Host != "" || parsed.RawQuery != "" || parsed.Fragment != "" || strings.Contains(basePath, "//") {
+		return fmt.Errorf("invalid -ntfy-base-path %q: must be a path such as /ntfy", basePath)
+	}
+	return nil
+}
+
+// isFatalConnectError reports whether err from connectNtfy is permanent, such
+// as a rejected token or unknown topic, so that retrying cannot help.
+func isFatalConnectError(err error) bool {
+	var connectErr *NtfyConnectError
+	if errors.As(err, &connectErr) {
+		switch connectErr.StatusCode {
+		case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return true
+		}
+	}
+	return false
+}
+
+// isNtfyMessageId reports whether since, a value of the since parameter, is a
+// message ID rather than "all", "latest", a Unix timestamp or a duration.
+func isNtfyMessageId(since string) bool {
+	switch since {
+	case "", "all", "latest":
+		return false
+	}
+	if _, err := strconv.ParseInt(strings.TrimSuffix(since, "d"), 10, 64); err == nil {
+		return false
+	}
+	_, err := time.ParseDuration(since)
+	return err != nil
+}
+
+// isAuthError reports whether err from connectNtfy is ntfy rejecting our token.
+func isAuthError(err error) bool {
+	var connectErr *NtfyConnectError
+	if errors.As(err, &connectErr) {
+		return connectErr.StatusCode == http.StatusUnauthorized || connectErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// ntfyRequestHeaders are the -ntfy-header headers sent with every request to ntfy.
+var ntfyRequestHeaders http.Header
+
+// parseHeaders parses "Key: Value" entries into headers.
+func parseHeaders(entries []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, errors.New(`expected "Key: Value"`)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" || strings.ContainsAny(key, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("%q: expected \"Key: Value\"", redactHeader(entry))
+		}
+		headers.Add(key, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// connectNtfy subscribes to the JSON stream of topic on domain, passing query
+// (e.g. since or poll) along. A non-200 response is returned as a *NtfyConnectError.
+func connectNtfy(client *http.Client, domain string, topic string, auth string, query url.Values) (*http.Response, error) {
+	u := ntfyUrl(domain, topic)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range ntfyRequestHeaders {
+		req.Header[key] = values
+	}
+	// negotiated here rather than left to the transport, which stops
+	// decompressing once Accept-Encoding is set, e.g. by -ntfy-header
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	req.Header.Set("User-Agent", *userAgent)
+	if auth != "" {
+		req.Header.Add("Authorization", "Bearer "+auth)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var body ntfyErrorBody
+		json.NewDecoder(io.LimitReader(resp.Body, 1024)).Decode(&body)
+		resp.Body.Close()
+		return nil, &NtfyConnectError{
+			Domain:     domain,
+			Topic:      topic,
+			StatusCode: resp.StatusCode,
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+			Code:       body.Code,
+			Message:    body.Error,
+			Token:      auth != "",
+		}
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		if err := decompressBody(resp); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("reading gzip stream from %s: %w", domain, err)
+		}
+	}
+	fmt.Printf("connected to %s in %s: %s, %s\n", domain, time.Since(start).Round(time.Millisecond), resp.Status, connectionInfo(resp))
+	return resp, nil
+}
+
+// gzipBody is a gzip-compressed response body, decompressed as it is read.
+// gzip decompresses whatever has been flushed, so events on a compressed
+// stream still arrive as they are sent.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
+
+// decompressBody replaces the gzip-compressed body of resp with its
+// decompressed content.
+func decompressBody(resp *http.Response) error {
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = &gzipBody{Reader: gz, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// connectionInfo describes the protocol and TLS version resp was received over.
+func connectionInfo(resp *http.Response) string {
+	if resp.TLS == nil {
+		return resp.Proto + " without TLS"
+	}
+	return resp.Proto + " over " + tlsVersionName(resp.TLS.Version)
+}
+
+// tlsVersionName names a TLS version, like tls.VersionName in Go 1.21.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("TLS 0x%04X", version)
+	}
+}
+
+// readLine reads the next newline-terminated line from r, without its line
+// ending. A line longer than max bytes is consumed but not returned, and
+// tooLong reports its length instead so the caller can skip it. A non-nil err
+// means the returned line was not terminated by a newline.
+func readLine(r *bufio.Reader, max int) (line []byte, tooLong int, err error) {
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if err == nil {
+			// the line ending does not count towards max
+			chunk = bytes.TrimSuffix(bytes.TrimSuffix(chunk, []byte("\n")), []byte("\r"))
+		}
+		if tooLong > 0 || len(line)+len(chunk) > max {
+			tooLong += len(line) + len(chunk)
+			line = nil
+		} else {
+			line = append(line, chunk...)
+		}
+		if err != bufio.ErrBufferFull {
+			return bytes.TrimRight(line, "\r\n"), tooLong, err
+		}
+	}
+}
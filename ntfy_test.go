@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLine(t *testing.T) {
+	const max = 20
+	exact := strings.Repeat("a", max)
+	oversized := strings.Repeat("b", max+1)
+	// a small buffer makes the longer lines span several reads
+	r := bufio.NewReaderSize(strings.NewReader(exact+"\n"+oversized+"\r\n"+"next\n"+"partial"), 16)
+
+	tests := []struct {
+		line    string
+		tooLong int
+		err     error
+	}{
+		{line: exact},
+		{tooLong: max + 1},
+		{line: "next"},
+		{line: "partial", err: io.EOF},
+	}
+	for i, tt := range tests {
+		line, tooLong, err := readLine(r, max)
+		if string(line) != tt.line || tooLong != tt.tooLong || err != tt.err {
+			t.Errorf("line %d: readLine() = %q, %d, %v, want %q, %d, %v", i, line, tooLong, err, tt.line, tt.tooLong, tt.err)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := retryAfter("120"); got != 2*time.Minute {
+		t.Errorf("retryAfter(\"120\") = %s, want 2m0s", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := retryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("retryAfter(%q) = %s, want about 1h", date, got)
+	}
+	for _, header := range []string{"", "0", "-5", "soon"} {
+		if got := retryAfter(header); got != 0 {
+			t.Errorf("retryAfter(%q) = %s, want 0", header, got)
+		}
+	}
+}
+
+func TestNtfyConnectErrorError(t *testing.T) {
+	tests := []struct {
+		err  NtfyConnectError
+		want string
+	}{
+		{NtfyConnectError{Domain: "ntfy.sh", StatusCode: 429}, "rate limited by ntfy server ntfy.sh"},
+		{NtfyConnectError{Domain: "ntfy.sh", Topic: "alerts", StatusCode: 403, Code: ntfyErrorForbidden}, "topic alerts on ntfy.sh is reserved; set -ntfy-auth to a token with read (subscribe) permission"},
+		{NtfyConnectError{Domain: "ntfy.sh", StatusCode: 500, Message: "internal error"}, "expected 200 OK from ntfy.sh, instead: 500 (internal error)"},
+		{NtfyConnectError{Domain: "ntfy.sh", StatusCode: 502}, "expected 200 OK from ntfy.sh, instead: 502"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("%+v.Error() = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestConnectNtfyForbidden(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"code":40301,"http":403,"error":"forbidden","link":"https://ntfy.sh/docs/publish/#authentication"}`)
+	}))
+	defer server.Close()
+	domain := strings.TrimPrefix(server.URL, "https://")
+
+	_, err := connectNtfy(server.Client(), domain, "alerts", "tk_writeonly", nil)
+	want := "token lacks read (subscribe) permission for topic alerts on " + domain
+	if err == nil || err.Error() != want {
+		t.Fatalf("connectNtfy() = %v, want %q", err, want)
+	}
+	if !isFatalConnectError(err) {
+		t.Errorf("isFatalConnectError(%v) = false, want true", err)
+	}
+}
+
+func TestSubscribeReconnectsAfterBadGateway(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests != 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.WriteString(w, `{"id":"a","time":1,"event":"message","topic":"alerts","message":"disk full"}`+"\n")
+	}))
+	defer server.Close()
+	domain := strings.TrimPrefix(server.URL, "https://")
+
+	s := &recordingSender{}
+	useSender(t, s)
+	setFlag(t, ntfyDomain, domain)
+	setFlag(t, ntfyTopic, "alerts")
+	setFlag(t, maxConnectAttempts, 3)
+	setFlag(t, &lastMessage, Cursor{})
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+
+	if code := subscribe(server.Client(), clock, []string{domain}, url.Values{}); code != 1 {
+		t.Errorf("subscribe() = %d once -max-connect-attempts is reached, want 1", code)
+	}
+	if requests != 6 {
+		t.Errorf("made %d requests, want 2 failed, 1 streamed and 3 more failed", requests)
+	}
+	delivered := false
+	for _, msg := range s.sent {
+		if msg.Source != nil && msg.Source.Id == "a" {
+			delivered = true
+		}
+	}
+	if !delivered {
+		t.Errorf("sent %+v, want the message streamed after two 502s", s.sent)
+	}
+	if len(clock.slept) != 5 || clock.slept[0] != reconnectDelay {
+		t.Errorf("slept %v, want %s before each of the 5 reconnects", clock.slept, reconnectDelay)
+	}
+}
+
+func TestSubscribeWaitsForRetryAfter(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	domain := strings.TrimPrefix(server.URL, "https://")
+
+	useSender(t, &recordingSender{})
+	setFlag(t, ntfyDomain, domain)
+	setFlag(t, ntfyTopic, "alerts")
+	setFlag(t, maxConnectAttempts, 2)
+	setFlag(t, &lastMessage, Cursor{})
+	clock := &fakeClock{}
+
+	subscribe(server.Client(), clock, []string{domain}, url.Values{})
+	if len(clock.slept) != 1 || clock.slept[0] != 2*time.Minute {
+		t.Errorf("slept %v after a 429 with Retry-After: 120, want [2m0s]", clock.slept)
+	}
+}
+
+func TestSubscribeFailoverResumesFromTime(t *testing.T) {
+	primary := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") == "" {
+			io.WriteString(w, `{"id":"abcdefghijkl","time":1700000000,"event":"message","topic":"alerts","message":"disk full"}`+"\n")
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+	var since []string
+	secondary := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since = append(since, r.URL.Query().Get("since"))
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer secondary.Close()
+	domains := []string{strings.TrimPrefix(primary.URL, "https://"), strings.TrimPrefix(secondary.URL, "https://")}
+
+	useSender(t, &recordingSender{})
+	setFlag(t, ntfyDomain, domains[0])
+	setFlag(t, ntfyTopic, "alerts")
+	setFlag(t, maxConnectAttempts, failoverAttempts+1)
+	setFlag(t, &lastMessage, Cursor{})
+
+	subscribe(primary.Client(), &fakeClock{now: time.Unix(1700000500, 0)}, domains, url.Values{})
+	if len(since) != 1 || since[0] != "1700000000" {
+		t.Errorf("failover server was asked for since=%q, want the time of the last message, 1700000000", since)
+	}
+}
+
+func TestSubscribeExitsOnceEveryServerRejects(t *testing.T) {
+	var requested []string
+	notFound := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.Host)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	primary := httptest.NewTLSServer(notFound)
+	defer primary.Close()
+	secondary := httptest.NewTLSServer(notFound)
+	defer secondary.Close()
+	domains := []string{strings.TrimPrefix(primary.URL, "https://"), strings.TrimPrefix(secondary.URL, "https://")}
+
+	useSender(t, &recordingSender{})
+	setFlag(t, ntfyDomain, domains[0])
+	setFlag(t, ntfyTopic, "alerts")
+	setFlag(t, &lastMessage, Cursor{})
+
+	if code := subscribe(primary.Client(), &fakeClock{}, domains, url.Values{}); code != 1 {
+		t.Errorf("subscribe() = %d, want 1", code)
+	}
+	if len(requested) != 2 || requested[1] != domains[1] {
+		t.Errorf("requested %v before exiting, want both servers once", requested)
+	}
+}
+
+func TestIsNtfyMessageId(t *testing.T) {
+	for since, want := range map[string]bool{
+		"":             false,
+		"all":          false,
+		"latest":       false,
+		"1700000000":   false,
+		"10m":          false,
+		"2d":           false,
+		"abcdefghijkl": true,
+	} {
+		if got := isNtfyMessageId(since); got != want {
+			t.Errorf("isNtfyMessageId(%q) = %t, want %t", since, got, want)
+		}
+	}
+}
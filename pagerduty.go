@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const pagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutySender triggers PagerDuty incidents through the Events API v2.
+// Messages forwarded from ntfy are deduplicated by their ntfy message ID.
+type PagerDutySender struct {
+	RoutingKey string
+	Client     *http.Client
+	UserAgent  string
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key,omitempty"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
+
+// pagerDutySeverity maps an ntfy priority (1-5) to a PagerDuty severity.
+func pagerDutySeverity(priority int) string {
+	switch priority {
+	case 5:
+		return "critical"
+	case 4:
+		return "error"
+	case 1, 2:
+		return "info"
+	default:
+		return "warning"
+	}
+}
+
+func (s *PagerDutySender) Send(msg SlackMessage) error {
+	event := pagerDutyEvent{
+		RoutingKey:  s.RoutingKey,
+		EventAction: "trigger",
+		Payload: pagerDutyPayload{
+			Summary:  msg.Text,
+			Source:   "ntfy-to-slack",
+			Severity: "info",
+		},
+	}
+	if msg.Source != nil {
+		event.DedupKey = msg.Source.Id
+		event.Payload.Source = msg.Source.Topic
+		event.Payload.Severity = pagerDutySeverity(msg.Source.Priority)
+		if msg.Source.Title != "" {
+			event.Payload.Summary = msg.Source.Title
+		}
+	}
+	// PagerDuty rejects summaries longer than 1024 characters.
+	event.Payload.Summary, _ = truncateText(event.Payload.Summary, 1024, false)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", pagerDutyEventsUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	debugf("pagerduty responded with %s", resp.Status)
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("pagerduty rejected event: %s %s", resp.Status, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// PostProcessor turns an ntfy message into the text sent to Slack, replacing
+// the default formatting.
+type PostProcessor interface {
+	Process(msg NtfyMessage) (string, error)
+}
+
+// ExecPostProcessor pipes each message as JSON to the stdin of Command and
+// uses whatever it prints to stdout as the Slack text. A non-zero exit status,
+// or running longer than Timeout, is an error. The ntfy message ID is also
+// passed in the CORRELATION_ID env var.
+type ExecPostProcessor struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (p *ExecPostProcessor) Process(msg NtfyMessage) (string, error) {
+	input, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Command)
+	cmd.Env = append(os.Environ(), "CORRELATION_ID="+msg.Id)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("post-process command %s timed out after %s", p.Command, p.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("post-process command %s failed: %w: %s", p.Command, err, msg)
+		}
+		return "", fmt.Errorf("post-process command %s failed: %w", p.Command, err)
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// PipelinePostProcessor runs each stage in order. Every stage after the first
+// sees the original message with its title cleared and its body replaced by
+// the previous stage's output, so the final stage's output is what is sent.
+type PipelinePostProcessor []PostProcessor
+
+func (p PipelinePostProcessor) Process(msg NtfyMessage) (string, error) {
+	var text string
+	for i, stage := range p {
+		if i > 0 {
+			msg.Title = ""
+			msg.Message = text
+		}
+
+		var err error
+		if text, err = stage.Process(msg); err != nil {
+			return "", fmt.Errorf("stage %d: %w", i+1, err)
+		}
+	}
+	return text, nil
+}
+
+// TemplatePostProcessor formats each message with the template for its topic,
+// or Default for any other topic. Messages for topics with neither get the
+// default formatting, which templates can also use as {{.Text}}.
+type TemplatePostProcessor struct {
+	Templates map[string]*template.Template
+	Default   *template.Template
+}
+
+// defaultTemplateTopic is the -template-for topic selecting the template used
+// for unmatched topics.
+const defaultTemplateTopic = "*"
+
+// NewTemplatePostProcessor reads and parses the template file for each topic
+// in files, keyed by topic or defaultTemplateTopic.
+func NewTemplatePostProcessor(files map[string]string) (*TemplatePostProcessor, error) {
+	p := &TemplatePostProcessor{Templates: make(map[string]*template.Template)}
+	for topic, path := range files {
+		text, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading template for topic %s: %w", topic, err)
+		}
+		tmpl, err := parseTemplate("template for "+topic, string(text))
+		if err != nil {
+			return nil, err
+		}
+		if topic == defaultTemplateTopic {
+			p.Default = tmpl
+		} else {
+			p.Templates[topic] = tmpl
+		}
+	}
+	return p, nil
+}
+
+func (p *TemplatePostProcessor) Process(msg NtfyMessage) (string, error) {
+	tmpl, ok := p.Templates[msg.Topic]
+	if !ok {
+		tmpl = p.Default
+	}
+	if tmpl == nil {
+		return literalText(formatMessage(msg)), nil
+	}
+
+	var text strings.Builder
+	if err := tmpl.Execute(&text, newTemplateData(msg, formatMessage(msg))); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// renderMessage formats msg with the configured post-processor, falling back
+// to the default formatting if there is none or it fails.
+func renderMessage(msg NtfyMessage) string {
+	if postProcessor == nil {
+		return literalText(formatMessage(msg))
+	}
+
+	text, err := postProcessor.Process(msg)
+	if err != nil {
+		fmt.Printf("post-process error for message %s: %s. falling back to default formatting\n", msg.Id, err)
+		return literalText(formatMessage(msg))
+	}
+	return text
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MessageQueue decouples reading the ntfy stream from forwarding messages, so
+// a slow destination doesn't stall the subscription. A single worker forwards
+// queued messages in order; when the queue is full, policy decides whether
+// Enqueue waits (block), or which message is dropped (drop-oldest,
+// drop-newest).
+type MessageQueue struct {
+	policy  string
+	forward func(msg NtfyMessage)
+	queue   chan NtfyMessage
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewMessageQueue starts a queue of size messages, forwarded by forward.
+func NewMessageQueue(size int, policy string, forward func(msg NtfyMessage)) *MessageQueue {
+	q := &MessageQueue{
+		policy:  policy,
+		forward: forward,
+		queue:   make(chan NtfyMessage, size),
+		done:    make(chan struct{}),
+	}
+	go q.work()
+	return q
+}
+
+func (q *MessageQueue) work() {
+	defer close(q.done)
+	for msg := range q.queue {
+		q.forward(msg)
+	}
+}
+
+// Enqueue queues msg for forwarding, applying the full-queue policy.
+// Messages enqueued after Drain are dropped.
+func (q *MessageQueue) Enqueue(msg NtfyMessage) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		fmt.Printf("warning: shutting down, dropping message %s\n", msg.Id)
+		return
+	}
+
+	select {
+	case q.queue <- msg:
+		return
+	default:
+	}
+
+	switch q.policy {
+	case "drop-newest":
+		fmt.Printf("warning: queue full, dropping message %s\n", msg.Id)
+	case "drop-oldest":
+		select {
+		case oldest := <-q.queue:
+			fmt.Printf("warning: queue full, dropping message %s\n", oldest.Id)
+		default:
+		}
+		q.queue <- msg
+	default:
+		debugf("queue full, waiting to queue message %s", msg.Id)
+		q.queue <- msg
+	}
+}
+
+// Drain stops accepting messages and waits for those already queued to be
+// forwarded.
+func (q *MessageQueue) Drain() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxQuietHeld caps how many messages quiet hours will hold; the oldest are
+// dropped beyond it.
+const maxQuietHeld = 1000
+
+// QuietHours holds messages below a priority during a daily window, such as
+// 22:00-07:00, and hands them to release when the window ends. Windows may
+// cross midnight, and are evaluated on the wall clock of their location, so
+// they follow DST changes.
+type QuietHours struct {
+	clock       Clock
+	start, end  int // minutes past midnight
+	location    *time.Location
+	minPriority int
+	release     func(msgs []NtfyMessage)
+
+	mu    sync.Mutex
+	held  []NtfyMessage
+	timer Timer
+}
+
+// NewQuietHours parses a window given as HH:MM-HH:MM in location. Messages of
+// minPriority or above are never held.
+func NewQuietHours(clock Clock, window string, location *time.Location, minPriority int, release func(msgs []NtfyMessage)) (*QuietHours, error) {
+	from, to, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid quiet hours %q: expected HH:MM-HH:MM", window)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours %q: %w", window, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours %q: %w", window, err)
+	}
+	if start == end {
+		return nil, fmt.Errorf("invalid quiet hours %q: start and end are the same", window)
+	}
+
+	return &QuietHours{clock: clock, start: start, end: end, location: location, minPriority: minPriority, release: release}, nil
+}
+
+// parseClock returns the minutes past midnight of an HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an HH:MM time", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Hold keeps msg until the window ends, and reports whether it did. Messages
+// outside the window, or urgent enough, are not held.
+func (q *QuietHours) Hold(msg NtfyMessage, now time.Time) bool {
+	if msg.priority() >= q.minPriority || !q.active(now) {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.held) >= maxQuietHeld {
+		fmt.Printf("warning: more than %d messages held for quiet hours, dropping message %s\n", maxQuietHeld, q.held[0].Id)
+		q.held = q.held[1:]
+	}
+	q.held = append(q.held, msg)
+	if q.timer == nil {
+		q.timer = q.clock.AfterFunc(q.nextEnd(now).Sub(now), q.Flush)
+	}
+	return true
+}
+
+// Flush immediately releases any held messages.
+func (q *QuietHours) Flush() {
+	q.mu.Lock()
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	held := q.held
+	q.held = nil
+	q.mu.Unlock()
+
+	if len(held) > 0 {
+		q.release(held)
+	}
+}
+
+// active reports whether t falls within the window.
+func (q *QuietHours) active(t time.Time) bool {
+	t = t.In(q.location)
+	minute := t.Hour()*60 + t.Minute()
+	if q.start < q.end {
+		return minute >= q.start && minute < q.end
+	}
+	return minute >= q.start || minute < q.end
+}
+
+// nextEnd returns the first end of the window after t.
+func (q *QuietHours) nextEnd(t time.Time) time.Time {
+	t = t.In(q.location)
+	end := time.Date(t.Year(), t.Month(), t.Day(), q.end/60, q.end%60, 0, 0, q.location)
+	if !end.After(t) {
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, q.end/60, q.end%60, 0, 0, q.location)
+	}
+	return end
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitedSender paces messages to Sender to at most Rate per second using
+// a token bucket. Messages over the limit wait for their turn rather than being
+// dropped; the wait for any one message is bounded by the number queued ahead of it.
+type RateLimitedSender struct {
+	Sender MessageSender
+	Rate   float64
+	Clock  Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimitedSender wraps sender, allowing rate messages per second.
+func NewRateLimitedSender(sender MessageSender, rate float64) *RateLimitedSender {
+	return &RateLimitedSender{
+		Sender: sender,
+		Rate:   rate,
+		Clock:  realClock{},
+		tokens: 1,
+		last:   time.Now(),
+	}
+}
+
+func (s *RateLimitedSender) Send(msg SlackMessage) error {
+	if delay := s.reserve(); delay > 0 {
+		debugf("rate limiting: delaying message for topic %s by %s", msg.Topic, delay)
+		s.Clock.Sleep(delay)
+	}
+	return s.Sender.Send(msg)
+}
+
+// reserve takes a token from the bucket and returns how long to wait before it
+// may be used. The bucket goes negative while messages are queued.
+func (s *RateLimitedSender) reserve() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Clock.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.Rate
+	if s.tokens > 1 {
+		s.tokens = 1
+	}
+	s.last = now
+
+	s.tokens--
+	if s.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-s.tokens / s.Rate * float64(time.Second))
+}
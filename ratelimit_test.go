@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimitedSenderWaitsForItsTurn(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	s := NewRateLimitedSender(&recordingSender{}, 0.5)
+	s.Clock = clock
+	s.last = clock.Now()
+
+	for i := 0; i < 3; i++ {
+		if err := s.Send(SlackMessage{Topic: "alerts"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []time.Duration{2 * time.Second, 2 * time.Second}
+	if len(clock.slept) != 2 || clock.slept[0] != want[0] || clock.slept[1] != want[1] {
+		t.Errorf("slept %v sending 3 messages at 0.5 per second, want %v", clock.slept, want)
+	}
+}
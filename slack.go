@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// truncatedSuffix marks text cut short by truncateText.
+const truncatedSuffix = "…[truncated]"
+
+// SlackMessage is a fully formatted message, ready to be handed to a MessageSender.
+// Source is the ntfy message it was rendered from, or nil for the bot's own
+// status messages and batches.
+type SlackMessage struct {
+	Topic     string       `json:"-"`
+	ThreadKey string       `json:"-"`
+	Source    *NtfyMessage `json:"-"`
+	Text      string       `json:"text"`
+	LinkNames bool         `json:"link_names,omitempty"`
+}
+
+// MarshalJSON sends Text in the field named by -slack-text-field.
+func (msg SlackMessage) MarshalJSON() ([]byte, error) {
+	payload := map[string]any{*slackTextField: msg.Text}
+	if msg.LinkNames {
+		payload["link_names"] = true
+	}
+	return json.Marshal(payload)
+}
+
+// truncateText shortens text to at most max characters, ending it with
+// truncatedSuffix, and reports whether it had to. A max of 0 disables
+// truncation. For markdownV2 text, the suffix is escaped and the cut never
+// separates a backslash from the character it escapes.
+func truncateText(text string, max int, markdownV2 bool) (string, bool) {
+	if max <= 0 || utf8.RuneCountInString(text) <= max {
+		return text, false
+	}
+
+	suffix := truncatedSuffix
+	if markdownV2 {
+		suffix = escapeMarkdownV2(suffix)
+	}
+	runes := []rune(text)
+	keep := max - utf8.RuneCountInString(suffix)
+	if keep < 0 {
+		keep = 0
+	}
+	if markdownV2 {
+		keep = markdownV2Cut(runes, keep)
+	}
+	return string(runes[:keep]) + suffix, true
+}
+
+// correlationId identifies the ntfy message msg was rendered from, for
+// following it through logs and downstream requests. It is empty for the
+// bot's own messages and batches.
+func (msg SlackMessage) correlationId() string {
+	if msg.Source == nil {
+		return ""
+	}
+	return msg.Source.Id
+}
+
+// setCorrelationHeader sets the X-Correlation-Id header of req to msg's
+// correlation ID, if it has one.
+func setCorrelationHeader(req *http.Request, msg SlackMessage) {
+	if id := msg.correlationId(); id != "" {
+		req.Header.Set("X-Correlation-Id", id)
+	}
+}
+
+// SlackError reports a message rejected by a Slack webhook.
+type SlackError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SlackError) Error() string {
+	return fmt.Sprintf("error sending msg. Status: %d %s", e.StatusCode, e.Body)
+}
+
+// MessageSender delivers a formatted message to its destination.
+type MessageSender interface {
+	Send(msg SlackMessage) error
+}
+
+// WebhookSender posts messages to a Slack incoming webhook, or any endpoint
+// that accepts the same JSON. Payload, if set, builds the request body in
+// place of the plain Slack message. CorrelationHeader sends the ntfy message
+// ID as X-Correlation-Id.
+type WebhookSender struct {
+	Url               string
+	Client            *http.Client
+	UserAgent         string
+	Payload           func(msg SlackMessage) (any, error)
+	CorrelationHeader bool
+}
+
+func (s *WebhookSender) Send(msg SlackMessage) error {
+	var payload any = msg
+	if s.Payload != nil {
+		var err error
+		if payload, err = s.Payload(msg); err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+	if s.CorrelationHeader {
+		setCorrelationHeader(req, msg)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	debugf("slack responded to %s for message %q with %s: %s", redactUrl(s.Url), msg.correlationId(), resp.Status, respBody)
+
+	if resp.StatusCode >= 400 {
+		return &SlackError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	// Slack answers "ok" on success, but can still report an error with a
+	// 200. Compatible endpoints answer with bodies of their own, such as
+	// Rocket.Chat's {"success":true}, so only Slack's errors are failures.
+	if result := strings.TrimSpace(string(respBody)); slackWebhookErrors[result] {
+		return &SlackError{StatusCode: resp.StatusCode, Body: result}
+	}
+	return nil
+}
+
+// slackWebhookErrors are the errors Slack incoming webhooks answer with.
+var slackWebhookErrors = map[string]bool{
+	"invalid_payload":                   true,
+	"invalid_blocks":                    true,
+	"invalid_blocks_format":             true,
+	"invalid_token":                     true,
+	"no_text":                           true,
+	"no_service":                        true,
+	"no_service_id":                     true,
+	"no_team":                           true,
+	"team_disabled":                     true,
+	"user_not_found":                    true,
+	"channel_not_found":                 true,
+	"channel_is_archived":               true,
+	"action_prohibited":                 true,
+	"posting_to_general_channel_denied": true,
+	"too_many_attachments":              true,
+	"rollup_error":                      true,
+}
+
+// slackAttachmentMessage is a Slack message rendered as a single legacy
+// attachment, with a color bar showing the ntfy priority.
+type slackAttachmentMessage struct {
+	Attachments []slackAttachment `json:"attachments"`
+	LinkNames   bool              `json:"link_names,omitempty"`
+}
+
+type slackAttachment struct {
+	Fallback string `json:"fallback"`
+	Color    string `json:"color"`
+	Title    string `json:"title,omitempty"`
+	Text     string `json:"text"`
+	Footer   string `json:"footer,omitempty"`
+}
+
+// slackPriorityColor maps an ntfy priority (1-5) to an attachment color.
+func slackPriorityColor(priority int) string {
+	switch priority {
+	case 5:
+		return "#d00000"
+	case 4:
+		return "#ffcc00"
+	case 3:
+		return "#439fe0"
+	default:
+		return "#cccccc"
+	}
+}
+
+// slackAttachmentPayload is a WebhookSender payload builder that shows the
+// ntfy title as the attachment title, for -slack-format=attachment. The bot's
+// own messages and batches have no single source message and are sent as text.
+func slackAttachmentPayload(msg SlackMessage) (any, error) {
+	if msg.Source == nil {
+		return msg, nil
+	}
+
+	attachment := slackAttachment{
+		Fallback: msg.Text,
+		Color:    slackPriorityColor(msg.Source.priority()),
+		Text:     msg.Text,
+	}
+	var footer []string
+	if *showMetadata {
+		footer = append(footer, metadataLine(*msg.Source))
+	}
+	if *slackFooter {
+		footer = append(footer, footerText(msg.Topic))
+	}
+	attachment.Footer = strings.Join(footer, " · ")
+	// a message with only a title already has it as its text
+	if msg.Source.Message != "" {
+		attachment.Title = msg.Source.Title
+		if attachment.Title != "" {
+			attachment.Fallback = attachment.Title + ": " + msg.Text
+		}
+	}
+	return slackAttachmentMessage{Attachments: []slackAttachment{attachment}, LinkNames: msg.LinkNames}, nil
+}
+
+// slackTemplatePayload is a WebhookSender payload builder sending the JSON
+// rendered by the -slack-payload-template as is.
+func slackTemplatePayload(msg SlackMessage) (any, error) {
+	body, err := renderJSONTemplate(slackPayloadTemplate, msg)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(body), nil
+}
+
+// RoutingSender delivers each message through the sender routed for its
+// priority or, failing that, its topic, falling back to Default when no route matches.
+type RoutingSender struct {
+	Routes         map[string]MessageSender
+	PriorityRoutes map[int]MessageSender
+	Default        MessageSender
+}
+
+func (s *RoutingSender) Send(msg SlackMessage) error {
+	if msg.Source != nil {
+		if route, ok := s.PriorityRoutes[msg.Source.priority()]; ok {
+			return route.Send(msg)
+		}
+	}
+	if route, ok := s.Routes[msg.Topic]; ok {
+		return route.Send(msg)
+	}
+	return s.Default.Send(msg)
+}
+
+// FanOutSender delivers every message through all of Senders at once, so a
+// slow webhook does not hold up the others. A failure is only returned if no
+// sender succeeded; partial failures are logged.
+type FanOutSender struct {
+	Senders []MessageSender
+}
+
+func (s *FanOutSender) Send(msg SlackMessage) error {
+	results := make([]error, len(s.Senders))
+	var wg sync.WaitGroup
+	for i, sender := range s.Senders {
+		wg.Add(1)
+		go func(i int, sender MessageSender) {
+			defer wg.Done()
+			results[i] = sender.Send(msg)
+		}(i, sender)
+	}
+	wg.Wait()
+
+	var errs []error
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhook %d: %w", i+1, err))
+		}
+	}
+	if len(errs) == len(s.Senders) {
+		return errors.Join(errs...)
+	}
+	if len(errs) > 0 {
+		fmt.Printf("warning: message for topic %s delivered to %d of %d webhooks: %s\n", msg.Topic, len(s.Senders)-len(errs), len(s.Senders), errors.Join(errs...))
+	}
+	return nil
+}
+
+// DryRunSender prints messages to stdout instead of sending them. Payload, if
+// set, builds the JSON printed in place of the message text, as it would be
+// for a WebhookSender.
+type DryRunSender struct {
+	Payload func(msg SlackMessage) (any, error)
+}
+
+func (s *DryRunSender) Send(msg SlackMessage) error {
+	text := msg.Text
+	if s.Payload != nil {
+		payload, err := s.Payload(msg)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		text = string(body)
+	}
+
+	if msg.ThreadKey != "" {
+		fmt.Printf("dry-run: would send to Slack (thread %q): %s\n", msg.ThreadKey, text)
+		return nil
+	}
+	fmt.Printf("dry-run: would send to Slack: %s\n", text)
+	return nil
+}
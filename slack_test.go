@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSender(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	s := &WebhookSender{Url: server.URL, Client: server.Client(), UserAgent: "ntfy-to-slack/test"}
+	if err := s.Send(SlackMessage{Topic: "alerts", Text: "disk full"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := req.Header.Get("User-Agent"); got != "ntfy-to-slack/test" {
+		t.Errorf("User-Agent = %q, want ntfy-to-slack/test", got)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("body %s is not JSON: %s", body, err)
+	}
+	if payload["text"] != "disk full" {
+		t.Errorf("body %s has text %v, want \"disk full\"", body, payload["text"])
+	}
+}
+
+func TestWebhookSenderErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no_service", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	s := &WebhookSender{Url: server.URL, Client: server.Client()}
+	err := s.Send(SlackMessage{Topic: "alerts", Text: "disk full"})
+	slackErr, ok := err.(*SlackError)
+	if !ok || slackErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Send() = %v, want a *SlackError with status 404", err)
+	}
+}
+
+func TestWebhookSenderErrorBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "invalid_payload")
+	}))
+	defer server.Close()
+
+	s := &WebhookSender{Url: server.URL, Client: server.Client()}
+	err := s.Send(SlackMessage{Topic: "alerts", Text: "disk full"})
+	slackErr, ok := err.(*SlackError)
+	if !ok || slackErr.Body != "invalid_payload" {
+		t.Fatalf("Send() = %v, want a *SlackError with body invalid_payload", err)
+	}
+}
+
+func TestWebhookSenderAcceptsOtherSuccessBodies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"success":true}`)
+	}))
+	defer server.Close()
+
+	s := &WebhookSender{Url: server.URL, Client: server.Client()}
+	if err := s.Send(SlackMessage{Topic: "alerts", Text: "disk full"}); err != nil {
+		t.Errorf("Send() = %v for a Rocket.Chat style success body, want nil", err)
+	}
+}
+
+// senderFunc adapts a function to a MessageSender.
+type senderFunc func(msg SlackMessage) error
+
+func (f senderFunc) Send(msg SlackMessage) error {
+	return f(msg)
+}
+
+func TestFanOutSenderSendsConcurrently(t *testing.T) {
+	second := make(chan struct{})
+	waited := false
+	slow := senderFunc(func(msg SlackMessage) error {
+		// only returns once the second webhook has been sent to
+		select {
+		case <-second:
+		case <-time.After(time.Second):
+			waited = true
+		}
+		return nil
+	})
+	fast := senderFunc(func(msg SlackMessage) error {
+		close(second)
+		return nil
+	})
+
+	s := &FanOutSender{Senders: []MessageSender{slow, fast}}
+	if err := s.Send(SlackMessage{Topic: "alerts", Text: "disk full"}); err != nil {
+		t.Fatal(err)
+	}
+	if waited {
+		t.Error("the second webhook was only sent to after the first returned")
+	}
+}
+
+func TestTruncateTextMarkdownV2(t *testing.T) {
+	// a cut after 5 characters would leave the backslash escaping "." behind
+	got, ok := truncateText(`abcd\.efghijklmnopqrstuvwxyz`, 5+len([]rune(escapeMarkdownV2(truncatedSuffix))), true)
+	if want := `abcd…\[truncated\]`; !ok || got != want {
+		t.Errorf("truncateText() = %q, %t, want %q, true", got, ok, want)
+	}
+}
+
+func TestWebhookSenderAttachmentPayload(t *testing.T) {
+	setFlag(t, slackFormat, "attachment")
+	setFlag(t, slackFooter, true)
+	setFlag(t, showTopic, false)
+
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	source := NtfyMessage{Id: "abc123", Time: 1, Event: "message", Topic: "alerts", Title: "disk", Message: "full", Priority: 5}
+	msg := newSlackMessage("alerts", formatMessage(source))
+	msg.Source = &source
+	s := &WebhookSender{Url: server.URL, Client: server.Client(), Payload: slackAttachmentPayload}
+	if err := s.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var payload struct {
+		Attachments []struct {
+			Fallback string `json:"fallback"`
+			Color    string `json:"color"`
+			Title    string `json:"title"`
+			Text     string `json:"text"`
+			Footer   string `json:"footer"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("body %s is not JSON: %s", body, err)
+	}
+	if len(payload.Attachments) != 1 {
+		t.Fatalf("body %s has %d attachments, want 1", body, len(payload.Attachments))
+	}
+	got := payload.Attachments[0]
+	if got.Color != "#d00000" || got.Title != "disk" || got.Text != "full" || got.Fallback != "disk: full" || got.Footer != footerText("alerts") {
+		t.Errorf("attachment = %+v, want color #d00000, title disk, text full, fallback \"disk: full\" and footer %q", got, footerText("alerts"))
+	}
+}
+
+func TestSlackMessageLinkNames(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		setFlag(t, slackLinkNames, enabled)
+		b, err := json.Marshal(newSlackMessage("alerts", "@here disk full"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var payload map[string]any
+		if err := json.Unmarshal(b, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := payload["link_names"]; ok != enabled {
+			t.Errorf("with -slack-link-names=%t, marshalled %s", enabled, b)
+		}
+	}
+}
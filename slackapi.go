@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const slackPostMessageUrl = "https://slack.com/api/chat.postMessage"
+
+// maxSlackThreads bounds how many thread roots APISender remembers.
+const maxSlackThreads = 10000
+
+// APISender posts messages to a channel with the Slack Web API's
+// chat.postMessage. Messages sharing a ThreadKey are posted as replies to the
+// first message sent with that key.
+type APISender struct {
+	Token     string
+	Channel   string
+	Client    *http.Client
+	UserAgent string
+
+	mu      sync.Mutex
+	threads map[string]string
+}
+
+type slackAPIMessage struct {
+	Channel   string `json:"channel"`
+	Text      string `json:"text"`
+	ThreadTs  string `json:"thread_ts,omitempty"`
+	LinkNames bool   `json:"link_names,omitempty"`
+}
+
+type slackAPIResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+	Ts    string `json:"ts"`
+}
+
+func (s *APISender) Send(msg SlackMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payload := slackAPIMessage{
+		Channel:   s.Channel,
+		Text:      msg.Text,
+		LinkNames: msg.LinkNames,
+	}
+	if msg.ThreadKey != "" {
+		payload.ThreadTs = s.threads[msg.ThreadKey]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", slackPostMessageUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	req.Header.Set("User-Agent", s.UserAgent)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result slackAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding chat.postMessage response (status %s): %w", resp.Status, err)
+	}
+	debugf("chat.postMessage responded with %s ok=%t ts=%s", resp.Status, result.Ok, result.Ts)
+	if !result.Ok {
+		return &SlackError{StatusCode: resp.StatusCode, Body: result.Error}
+	}
+
+	if msg.ThreadKey != "" && payload.ThreadTs == "" {
+		if s.threads == nil || len(s.threads) >= maxSlackThreads {
+			s.threads = make(map[string]string)
+		}
+		s.threads[msg.ThreadKey] = result.Ts
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const telegramApiUrl = "https://api.telegram.org"
+
+// telegramMaxLength is the longest message the Telegram Bot API accepts.
+const telegramMaxLength = 4096
+
+// TelegramSender delivers messages to a Telegram chat through the Bot API's
+// sendMessage, splitting anything longer than Telegram allows into several messages.
+type TelegramSender struct {
+	Token     string
+	ChatId    string
+	ParseMode string
+	Client    *http.Client
+	UserAgent string
+}
+
+type telegramMessage struct {
+	ChatId    string `json:"chat_id"`
+	Text      string `json:"text"`
+	ParseMode string `json:"parse_mode,omitempty"`
+}
+
+type telegramResponse struct {
+	Ok          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+func (s *TelegramSender) Send(msg SlackMessage) error {
+	for _, chunk := range splitText(msg.Text, telegramMaxLength, s.ParseMode == "MarkdownV2") {
+		if err := s.send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *TelegramSender) send(text string) error {
+	body, err := json.Marshal(telegramMessage{
+		ChatId:    s.ChatId,
+		Text:      text,
+		ParseMode: s.ParseMode,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", telegramApiUrl+"/bot"+s.Token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		// the request URL contains the bot token, keep it out of logs
+		return fmt.Errorf("telegram sendMessage failed: %w", unwrapUrlError(err))
+	}
+	defer resp.Body.Close()
+
+	var result telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("decoding telegram response (status %s): %w", resp.Status, err)
+	}
+	debugf("telegram responded with %s ok=%t", resp.Status, result.Ok)
+	if !result.Ok {
+		return fmt.Errorf("telegram rejected message: %s %s", resp.Status, result.Description)
+	}
+	return nil
+}
+
+// markdownV2Reserved are the characters Telegram's MarkdownV2 requires to be
+// escaped wherever they are meant literally.
+const markdownV2Reserved = "_*[]()~`>#+-=|{}.!\\"
+
+// escapeMarkdownV2 escapes every MarkdownV2 reserved character in text.
+func escapeMarkdownV2(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(markdownV2Reserved, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// markdownV2Cut moves cut, an index into runes, back by one if it would
+// separate a backslash from the character it escapes.
+func markdownV2Cut(runes []rune, cut int) int {
+	backslashes := 0
+	for i := cut - 1; i >= 0 && runes[i] == '\\'; i-- {
+		backslashes++
+	}
+	if backslashes%2 == 1 {
+		return cut - 1
+	}
+	return cut
+}
+
+// splitText splits text into chunks of at most max characters, breaking at the
+// last newline within a chunk where there is one. Chunks of markdownV2 text
+// never end in a backslash escaping the first character of the next.
+func splitText(text string, max int, markdownV2 bool) []string {
+	var chunks []string
+	runes := []rune(text)
+	for len(runes) > max {
+		cut := max
+		for i := max - 1; i > 0; i-- {
+			if runes[i] == '\n' {
+				cut = i
+				break
+			}
+		}
+		if markdownV2 {
+			if safe := markdownV2Cut(runes, cut); safe > 0 {
+				cut = safe
+			}
+		}
+		chunks = append(chunks, string(runes[:cut]))
+		runes = runes[cut:]
+		if runes[0] == '\n' {
+			runes = runes[1:]
+		}
+	}
+	return append(chunks, string(runes))
+}
+
+// unwrapUrlError strips the request URL from a client error, for URLs that
+// embed a secret.
+func unwrapUrlError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		markdownV2 bool
+		want       []string
+	}{
+		{name: "short", text: "abc", want: []string{"abc"}},
+		{name: "at newline", text: "ab\ncdef", want: []string{"ab", "cdef"}},
+		{name: "plain", text: `abc\.def`, want: []string{`abc\`, `.def`}},
+		{name: "markdownV2 escape", text: `abc\.def`, markdownV2: true, want: []string{"abc", `\.de`, "f"}},
+		{name: "markdownV2 backslash", text: `ab\\cdef`, markdownV2: true, want: []string{`ab\\`, "cdef"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitText(tt.text, 4, tt.markdownV2); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// TemplateData is what templates are executed against: the ntfy message's
+// fields, so {{.Title}} works as before, plus the -template-var values as
+// {{.Vars}} and, where a template renders output, the formatted {{.Text}}.
+// Attachment shadows the message's pointer so that {{.Attachment.URL}} is
+// empty rather than an error for messages without one; test for an
+// attachment with {{if .Attachment.URL}}.
+type TemplateData struct {
+	NtfyMessage
+	Attachment Attachment
+	Vars       map[string]string
+	Text       string
+}
+
+func newTemplateData(msg NtfyMessage, text string) TemplateData {
+	data := TemplateData{
+		NtfyMessage: msg,
+		Vars:        templateVars,
+		Text:        text,
+	}
+	if msg.Attachment != nil {
+		data.Attachment = *msg.Attachment
+	}
+	return data
+}
+
+// templateFuncs are available in every template.
+var templateFuncs = template.FuncMap{
+	// json encodes a value as JSON, e.g. {"title": {{json .Title}}}
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	// mention turns a -mention-map name into a Slack mention, e.g.
+	// {{mention "alice"}} is <@U123>. Unknown names are left as they are.
+	"mention": func(name string) string {
+		if id, ok := mentionMap[name]; ok {
+			return "<@" + id + ">"
+		}
+		return name
+	},
+}
+
+// parseTemplate parses text as a template with templateFuncs available, and
+// checks it executes against an example message, catching mistakes such as
+// misspelt fields at startup rather than on the first real message. With
+// -template-missingkey=error, that includes using a {{.Vars}} key that no
+// -template-var sets.
+func parseTemplate(name string, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=" + *templateMissingKey).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	example := NtfyMessage{Id: "example", Time: 1, Event: "message", Topic: "example", Title: "example", Message: "example"}
+	if err := tmpl.Execute(io.Discard, newTemplateData(example, "example")); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// templateSource returns the ntfy message msg was rendered from. The bot's own
+// messages have none, so templates only see their .Topic and .Text.
+func templateSource(msg SlackMessage) NtfyMessage {
+	if msg.Source == nil {
+		return NtfyMessage{Topic: msg.Topic}
+	}
+	return *msg.Source
+}
+
+// renderJSONTemplate executes tmpl for msg, which must produce valid JSON.
+func renderJSONTemplate(tmpl *template.Template, msg SlackMessage) ([]byte, error) {
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, newTemplateData(templateSource(msg), msg.Text)); err != nil {
+		return nil, fmt.Errorf("rendering %s: %w", tmpl.Name(), err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, fmt.Errorf("%s rendered invalid JSON: %s", tmpl.Name(), body.String())
+	}
+	return body.Bytes(), nil
+}
+
+// readTemplateFlags replaces the value of each of flags, by flag name, that is
+// "@-" with a template read from stdin, which is easier than squeezing a
+// multi-line template into a flag. Stdin can only be read once, so only one
+// flag may use it.
+func readTemplateFlags(flags map[string]*string) error {
+	var fromStdin []string
+	for name, value := range flags {
+		if *value == "@-" {
+			fromStdin = append(fromStdin, "-"+name)
+		}
+	}
+	switch len(fromStdin) {
+	case 0:
+		return nil
+	case 1:
+	default:
+		sort.Strings(fromStdin)
+		return fmt.Errorf("only one template can be read from stdin, not %s", strings.Join(fromStdin, " and "))
+	}
+
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("%s: reading template from stdin: %w", fromStdin[0], err)
+	}
+	*flags[strings.TrimPrefix(fromStdin[0], "-")] = strings.TrimRight(string(b), "\n")
+	return nil
+}
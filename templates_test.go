@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadTemplateFlags(t *testing.T) {
+	stdin := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(stdin, []byte("{{.Title}}\n{{.Message}}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	old := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = old }()
+
+	heartbeat, threadKey := "@-", "{{.Title}}"
+	if err := readTemplateFlags(map[string]*string{"heartbeat-message": &heartbeat, "slack-thread-key": &threadKey}); err != nil {
+		t.Fatal(err)
+	}
+	if heartbeat != "{{.Title}}\n{{.Message}}" {
+		t.Errorf("-heartbeat-message @- read %q from stdin", heartbeat)
+	}
+	if threadKey != "{{.Title}}" {
+		t.Errorf("-slack-thread-key changed to %q, want it as given", threadKey)
+	}
+}
+
+func TestReadTemplateFlagsStdinOnce(t *testing.T) {
+	a, b := "@-", "@-"
+	if err := readTemplateFlags(map[string]*string{"output-template": &a, "digest-template": &b}); err == nil {
+		t.Error("two templates read from stdin, want an error")
+	}
+}
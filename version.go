@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build identity, stamped at build time with e.g.
+//
+//	go build -ldflags "-X main.Version=v1.3 -X main.Commit=$(git rev-parse HEAD) -X main.BuildDate=$(date -u +%Y-%m-%d)"
+var (
+	Version   = "v1.2"
+	Commit    = ""
+	BuildDate = "2023-03-01"
+)
+
+// versionString returns the short version, e.g. "v1.2 2023-03-01".
+func versionString() string {
+	return Version + " " + BuildDate
+}
+
+// versionDetailed returns a multi-line description of the build, suitable for bug reports.
+func versionDetailed() string {
+	commit := Commit
+	if commit == "" {
+		commit = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					commit = setting.Value
+				}
+			}
+		}
+	}
+
+	return fmt.Sprintf("ntfy-to-slack %s\ncommit: %s\nbuild date: %s\ngo: %s %s/%s",
+		Version, commit, BuildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// WorkflowSender starts a Slack Workflow Builder workflow through its webhook
+// for each message. Workflow webhooks take a flat object of string variables
+// rather than a message, each rendered here by its template in Vars.
+type WorkflowSender struct {
+	Url               string
+	Vars              map[string]*template.Template
+	Client            *http.Client
+	UserAgent         string
+	CorrelationHeader bool
+}
+
+// workflowResponse is the JSON a workflow webhook answers with.
+type workflowResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (s *WorkflowSender) Send(msg SlackMessage) error {
+	vars, err := workflowVariables(s.Vars, msg)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(vars)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.UserAgent)
+	if s.CorrelationHeader {
+		setCorrelationHeader(req, msg)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	debugf("slack workflow responded to %s for message %q with %s: %s", redactUrl(s.Url), msg.correlationId(), resp.Status, respBody)
+
+	if resp.StatusCode >= 300 {
+		return &SlackError{StatusCode: resp.StatusCode, Body: string(respBody)}
+	}
+	var result workflowResponse
+	if err := json.Unmarshal(respBody, &result); err == nil && !result.Ok {
+		return &SlackError{StatusCode: resp.StatusCode, Body: result.Error}
+	}
+	return nil
+}
+
+// workflowVariables renders every -workflow-var template for msg.
+func workflowVariables(vars map[string]*template.Template, msg SlackMessage) (map[string]string, error) {
+	source := templateSource(msg)
+	values := make(map[string]string, len(vars))
+	for name, tmpl := range vars {
+		var value strings.Builder
+		if err := tmpl.Execute(&value, newTemplateData(source, msg.Text)); err != nil {
+			return nil, fmt.Errorf("rendering workflow variable %s: %w", name, err)
+		}
+		values[name] = value.String()
+	}
+	return values, nil
+}
+
+// parseWorkflowVars parses each -workflow-var name=template.
+func parseWorkflowVars(vars keyValueFlag) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template, len(vars))
+	for name, text := range vars {
+		tmpl, err := parseTemplate("workflow-var "+name, text)
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}